@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Nebyat19/Torrent-Streamer/logger"
+	"github.com/google/uuid"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+var (
+	accessLog = logger.Component("access")
+
+	// videoSampleRate logs one in N ranged /video requests; players issue
+	// many of these per minute and they are rarely interesting.
+	videoSampleRate   = accessLogSampleRate()
+	videoRequestsSeen atomic.Uint64
+)
+
+func accessLogSampleRate() uint64 {
+	n, err := strconv.ParseUint(os.Getenv("ACCESS_LOG_VIDEO_SAMPLE"), 10, 64)
+	if err != nil || n == 0 {
+		return 20
+	}
+	return n
+}
+
+// requestID honors a sane incoming X-Request-ID so IDs can be traced across
+// proxies, and generates one otherwise.
+func requestID(r *http.Request) string {
+	id := r.Header.Get(requestIDHeader)
+	if id == "" || len(id) > 128 {
+		return uuid.New().String()
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return uuid.New().String()
+		}
+	}
+	return id
+}
+
+// requestSessionID returns the session a request belongs to without
+// creating one, for logging.
+func requestSessionID(r *http.Request, w http.ResponseWriter) string {
+	if cookie, err := r.Cookie("ts_session_id"); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	if id := r.URL.Query().Get("session"); id != "" {
+		return id
+	}
+	// A session created during this request is only visible in Set-Cookie
+	for _, c := range w.Header().Values("Set-Cookie") {
+		if value, ok := strings.CutPrefix(c, "ts_session_id="); ok {
+			value, _, _ = strings.Cut(value, ";")
+			return value
+		}
+	}
+	return ""
+}
+
+func logAccess(name string, r *http.Request, rw *responseRecorder, reqID string, duration time.Duration) {
+	if name == "video" && rw.status < 400 && !isInitialRange(r) {
+		if videoRequestsSeen.Add(1)%videoSampleRate != 0 {
+			return
+		}
+	}
+
+	fields := []interface{}{
+		logger.RequestID(reqID),
+		logger.F("method", r.Method),
+		logger.F("path", r.URL.Path),
+		logger.F("status", rw.status),
+		logger.F("bytes", rw.bytes),
+		logger.F("duration_ms", duration.Milliseconds()),
+		logger.F("remote", getClientIP(r)),
+	}
+	if sessionID := requestSessionID(r, rw); sessionID != "" {
+		fields = append(fields, logger.SessionID(sessionID))
+	}
+	if rng := r.Header.Get("Range"); rng != "" {
+		fields = append(fields, logger.F("range", rng))
+	}
+
+	if rw.status >= 500 {
+		accessLog.Error("%s %s %d", append([]interface{}{r.Method, r.URL.Path, rw.status}, fields...)...)
+	} else {
+		accessLog.Info("%s %s %d", append([]interface{}{r.Method, r.URL.Path, rw.status}, fields...)...)
+	}
+}
+
+// isInitialRange reports whether a request starts playback from the top,
+// which we always log even when sampling.
+func isInitialRange(r *http.Request) bool {
+	rng := r.Header.Get("Range")
+	return rng == "" || strings.HasPrefix(rng, "bytes=0-")
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Nebyat19/Torrent-Streamer/logger"
+)
+
+// adminHandler guards operator-only endpoints. The request must carry
+// "Authorization: Bearer <token>" matching ADMIN_TOKEN; without a token the
+// endpoints are disabled.
+func adminHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdminRequest(r) {
+			logger.Warn("Rejected admin request to %s from %s", r.URL.Path, getClientIP(r))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			respondJSON(w, APIResponse{Success: false, Code: codeForbidden, Error: "Forbidden"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isAdminRequest checks the request's bearer token. Loopback clients aren't
+// trusted: behind a proxy such as Knative's queue-proxy every request
+// comes from 127.0.0.1.
+func isAdminRequest(r *http.Request) bool {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		return false
+	}
+	provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
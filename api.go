@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Nebyat19/Torrent-Streamer/apiclient"
+)
+
+// apiPrefix is the versioned API namespace. The unversioned /api/... paths
+// are deprecated aliases that keep their old behaviour.
+const apiPrefix = "/api/v1"
+
+// Machine-readable error codes returned in APIResponse.Code
+const (
+	codeInvalidJSON         = "invalid_json"
+	codeInvalidRequest      = "invalid_request"
+	codeInvalidMagnet       = "invalid_magnet"
+	codeInvalidTorrent      = "invalid_torrent"
+	codeInvalidSession      = "invalid_session"
+	codeUnsupportedSubtitle = "unsupported_subtitle"
+	codeFileTooLarge        = "file_too_large"
+	codeForbidden           = "forbidden"
+	codeNotFound            = "not_found"
+	codeMethodNotAllowed    = "method_not_allowed"
+	codeNoFileSelected      = "no_file_selected"
+	codeNoPlaylist          = "no_playlist"
+	codeNoStream            = "no_stream"
+	codeAlreadyExists       = "already_exists"
+	codeTooManyRequests     = "too_many_requests"
+	codeInternal            = "internal_error"
+)
+
+// apiRoute registers a handler under /api/v1 and at its old unversioned path
+func apiRoute(path, name string, handler http.HandlerFunc) {
+	http.HandleFunc(apiPrefix+path, corsHandler(safeHTTPHandler(name, handler)))
+	http.HandleFunc("/api"+path, corsHandler(safeHTTPHandler(name, deprecated(apiPrefix+path, handler))))
+}
+
+// apiOpenAPIHandler serves the OpenAPI document, which lives with the Go
+// client so the two are updated together
+func apiOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(apiclient.Spec)
+}
+
+// respondError reports a failed API call with its HTTP status and error code
+func respondError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, legacy := w.(legacyWriter); !legacy {
+		w.WriteHeader(status)
+	}
+	respondJSON(w, APIResponse{Success: false, Code: code, Error: message})
+}
+
+// methods routes a request by its HTTP method. Anything else is answered
+// with 405 and an Allow header; HEAD is served by the GET handler.
+type methods map[string]http.HandlerFunc
+
+func (m methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, ok := m[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		handler, ok = m[http.MethodGet]
+	}
+	if ok {
+		handler(w, r)
+		return
+	}
+
+	allowed := make([]string, 0, len(m))
+	for method := range m {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	respondError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+}
+
+// deprecated marks a response from an unversioned alias and points clients
+// at the /api/v1 route that replaces it
+func deprecated(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		next(legacyWriter{w}, r)
+	}
+}
+
+// legacyWriter tells respondError that the client predates /api/v1, where
+// failures were reported with 200 and "success": false. Older clients only
+// look at the body, so the aliases keep doing that.
+type legacyWriter struct {
+	http.ResponseWriter
+}
+
+func (lw legacyWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (lw legacyWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
@@ -0,0 +1,460 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Spec is the OpenAPI 3 document describing the API this package talks to
+//
+//go:embed openapi.json
+var Spec []byte
+
+// Error codes the server returns in Error.Code
+const (
+	CodeInvalidJSON         = "invalid_json"
+	CodeInvalidRequest      = "invalid_request"
+	CodeInvalidMagnet       = "invalid_magnet"
+	CodeInvalidTorrent      = "invalid_torrent"
+	CodeInvalidSession      = "invalid_session"
+	CodeUnsupportedSubtitle = "unsupported_subtitle"
+	CodeFileTooLarge        = "file_too_large"
+	CodeForbidden           = "forbidden"
+	CodeNotFound            = "not_found"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeNoFileSelected      = "no_file_selected"
+	CodeNoPlaylist          = "no_playlist"
+	CodeNoStream            = "no_stream"
+	CodeAlreadyExists       = "already_exists"
+	CodeTooManyRequests     = "too_many_requests"
+	CodeInternal            = "internal_error"
+)
+
+// Error is a failed API call
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RequestID  string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("torrent streamer: %s (HTTP %d", e.Message, e.StatusCode)
+	if e.Code != "" {
+		msg += ", " + e.Code
+	}
+	if e.RequestID != "" {
+		msg += ", request " + e.RequestID
+	}
+	return msg + ")"
+}
+
+// Client drives a Torrent Streamer server. The server keeps one stream per
+// session cookie, so calls only share state when they go through an
+// http.Client with a cookie jar.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+
+	// AdminToken is sent as a bearer token with every request. The admin
+	// endpoints require it, and with it Downloads, Queue and the calls
+	// taking an infoHash reach every user's torrents rather than only this
+	// client's own.
+	AdminToken string
+}
+
+// New returns a client for the server at baseURL, e.g. "http://localhost:8080".
+// A nil httpClient gets a fresh one with an in-memory cookie jar.
+func New(baseURL string, httpClient *http.Client) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("torrent streamer: base URL %q needs a scheme and host", baseURL)
+	}
+	if httpClient == nil {
+		jar, _ := cookiejar.New(nil)
+		httpClient = &http.Client{Jar: jar}
+	}
+	return &Client{baseURL: u, httpClient: httpClient}, nil
+}
+
+// URL resolves a path returned by the server, such as Status.VideoURL or
+// Subtitle.Path, against the base URL
+func (c *Client) URL(path string) string {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return c.baseURL.String() + path
+	}
+	return c.baseURL.ResolveReference(ref).String()
+}
+
+// Status returns the session's current stream
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var status Status
+	if err := c.do(ctx, "GET", "/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Stream starts streaming a magnet link, replacing the session's current
+// torrent. Metadata arrives in the background; poll Status until VideoURL
+// is set.
+func (c *Client) Stream(ctx context.Context, magnet string) error {
+	return c.do(ctx, "POST", "/stream", map[string]string{"magnet": magnet}, nil)
+}
+
+// StreamTorrent is Stream for a .torrent file, which skips the metadata
+// fetch
+func (c *Client) StreamTorrent(ctx context.Context, name string, r io.Reader) error {
+	return c.upload(ctx, "/stream", "torrent", name, r)
+}
+
+// StopStream drops the session's torrent, also while its metadata is still
+// being fetched, and keeps the session
+func (c *Client) StopStream(ctx context.Context) error {
+	return c.do(ctx, "DELETE", "/stream", nil, nil)
+}
+
+// Progress returns the download progress of the selected file
+func (c *Client) Progress(ctx context.Context) (*Progress, error) {
+	var progress Progress
+	if err := c.do(ctx, "GET", "/progress", nil, &progress); err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+// UploadSubtitle adds a subtitle file to the session. The name's extension
+// selects the format.
+func (c *Client) UploadSubtitle(ctx context.Context, name string, r io.Reader) error {
+	return c.upload(ctx, "/upload-subtitle", "subtitle", name, r)
+}
+
+// upload posts a file as a multipart form field
+func (c *Client) upload(ctx context.Context, path, field, name string, r io.Reader) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile(field, name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return err
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(ctx, "POST", path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return c.send(req, nil)
+}
+
+// Pieces returns which parts of the selected file are downloaded. duration
+// in seconds maps them to playback time when the server could not probe
+// the file; pass 0 to leave it to the server.
+func (c *Client) Pieces(ctx context.Context, duration float64) (*PieceMap, error) {
+	path := "/pieces"
+	if duration > 0 {
+		path += "?duration=" + strconv.FormatFloat(duration, 'f', -1, 64)
+	}
+	var pieces PieceMap
+	if err := c.do(ctx, "GET", path, nil, &pieces); err != nil {
+		return nil, err
+	}
+	return &pieces, nil
+}
+
+// History returns the user's watch history, most recent first
+func (c *Client) History(ctx context.Context) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	if err := c.do(ctx, "GET", "/history", nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// DeleteHistory forgets a title
+func (c *Client) DeleteHistory(ctx context.Context, infoHash string) error {
+	return c.do(ctx, "DELETE", "/history?infoHash="+url.QueryEscape(infoHash), nil, nil)
+}
+
+// ReportPosition records the playback position in seconds, which is where
+// the title resumes next time
+func (c *Client) ReportPosition(ctx context.Context, position, duration float64) (*HistoryEntry, error) {
+	request := map[string]float64{"position": position, "duration": duration}
+	var entry HistoryEntry
+	if err := c.do(ctx, "POST", "/position", request, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Playlist returns the torrent's video files in play order
+func (c *Client) Playlist(ctx context.Context) ([]PlaylistItem, error) {
+	var items []PlaylistItem
+	if err := c.do(ctx, "GET", "/playlist", nil, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Next plays the next playlist item
+func (c *Client) Next(ctx context.Context) ([]PlaylistItem, error) {
+	return c.playlistAction(ctx, "next", 0)
+}
+
+// Previous plays the previous playlist item
+func (c *Client) Previous(ctx context.Context) ([]PlaylistItem, error) {
+	return c.playlistAction(ctx, "previous", 0)
+}
+
+// Select plays the playlist item at index
+func (c *Client) Select(ctx context.Context, index int) ([]PlaylistItem, error) {
+	return c.playlistAction(ctx, "select", index)
+}
+
+func (c *Client) playlistAction(ctx context.Context, action string, index int) ([]PlaylistItem, error) {
+	request := struct {
+		Action string `json:"action"`
+		Index  int    `json:"index"`
+	}{action, index}
+	var items []PlaylistItem
+	if err := c.do(ctx, "POST", "/playlist", request, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ResetSession drops the session's torrent and forgets the session
+func (c *Client) ResetSession(ctx context.Context) error {
+	return c.do(ctx, "POST", "/reset-session", nil, nil)
+}
+
+// Downloads returns the background downloads this client added, or all of
+// them with an AdminToken
+func (c *Client) Downloads(ctx context.Context) ([]DownloadJob, error) {
+	var jobs []DownloadJob
+	if err := c.do(ctx, "GET", "/downloads", nil, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// AddDownload downloads a magnet link into the library, independent of the
+// session. files limits it to those paths within the torrent; priority is
+// PriorityUser or PriorityBackground, empty meaning the former.
+func (c *Client) AddDownload(ctx context.Context, magnet string, files []string, priority string) (*DownloadJob, error) {
+	request := struct {
+		Magnet   string   `json:"magnet"`
+		Files    []string `json:"files,omitempty"`
+		Priority string   `json:"priority,omitempty"`
+	}{magnet, files, priority}
+	var job DownloadJob
+	if err := c.do(ctx, "POST", "/downloads", request, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CancelDownload stops a download and forgets it; finished files stay in
+// the library
+func (c *Client) CancelDownload(ctx context.Context, id string) error {
+	return c.do(ctx, "DELETE", "/downloads?id="+url.QueryEscape(id), nil, nil)
+}
+
+// Trackers scrapes the trackers of a torrent, or of the session's stream
+// when infoHash is empty. Trackers that don't answer take up to ten seconds.
+func (c *Client) Trackers(ctx context.Context, infoHash string) ([]TrackerStatus, error) {
+	path := "/trackers"
+	if infoHash != "" {
+		path += "?infoHash=" + url.QueryEscape(infoHash)
+	}
+	var trackers []TrackerStatus
+	if err := c.do(ctx, "GET", path, nil, &trackers); err != nil {
+		return nil, err
+	}
+	return trackers, nil
+}
+
+// AddTrackers adds trackers to a running torrent, or to the session's
+// stream when infoHash is empty
+func (c *Client) AddTrackers(ctx context.Context, infoHash string, trackers []string) error {
+	request := struct {
+		InfoHash string   `json:"infoHash,omitempty"`
+		Trackers []string `json:"trackers"`
+	}{infoHash, trackers}
+	return c.do(ctx, "POST", "/trackers", request, nil)
+}
+
+// AddPeers connects a running torrent, or the session's stream when
+// infoHash is empty, to host:port peers
+func (c *Client) AddPeers(ctx context.Context, infoHash string, peers []string) error {
+	request := struct {
+		InfoHash string   `json:"infoHash,omitempty"`
+		Peers    []string `json:"peers"`
+	}{infoHash, peers}
+	return c.do(ctx, "POST", "/peers", request, nil)
+}
+
+// Queue returns this client's torrents competing for the server's download
+// slots, or every torrent with an AdminToken
+func (c *Client) Queue(ctx context.Context) (*QueueStatus, error) {
+	var status QueueStatus
+	if err := c.do(ctx, "GET", "/queue", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Pause stops a torrent until Resume, whatever its priority
+func (c *Client) Pause(ctx context.Context, infoHash string) (*QueueStatus, error) {
+	return c.queueAction(ctx, infoHash, "pause", 0)
+}
+
+// Resume lets a paused torrent take a slot again
+func (c *Client) Resume(ctx context.Context, infoHash string) (*QueueStatus, error) {
+	return c.queueAction(ctx, infoHash, "resume", 0)
+}
+
+// MoveInQueue moves a torrent to a position in the queue. It stays behind
+// torrents with a higher priority.
+func (c *Client) MoveInQueue(ctx context.Context, infoHash string, position int) (*QueueStatus, error) {
+	return c.queueAction(ctx, infoHash, "move", position)
+}
+
+func (c *Client) queueAction(ctx context.Context, infoHash, action string, position int) (*QueueStatus, error) {
+	request := struct {
+		InfoHash string `json:"infoHash"`
+		Action   string `json:"action"`
+		Position int    `json:"position"`
+	}{infoHash, action, position}
+	var status QueueStatus
+	if err := c.do(ctx, "POST", "/queue", request, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// LogLevels returns the server's log level per component
+func (c *Client) LogLevels(ctx context.Context) (map[string]string, error) {
+	var levels map[string]string
+	if err := c.do(ctx, "GET", "/admin/log-level", nil, &levels); err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+// SetLogLevels applies a level spec such as "info,http=debug"
+func (c *Client) SetLogLevels(ctx context.Context, spec string) (map[string]string, error) {
+	var levels map[string]string
+	if err := c.do(ctx, "POST", "/admin/log-level", map[string]string{"spec": spec}, &levels); err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+// TrackerList returns the default tracker list added to torrents
+func (c *Client) TrackerList(ctx context.Context) (*TrackerList, error) {
+	var list TrackerList
+	if err := c.do(ctx, "GET", "/admin/trackers", nil, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// SetTrackerList replaces the default tracker list. A nil list refreshes
+// it instead, from the server's TRACKERS_URL or its file.
+func (c *Client) SetTrackerList(ctx context.Context, trackers []string) (*TrackerList, error) {
+	var request interface{}
+	if trackers != nil {
+		request = map[string][]string{"trackers": trackers}
+	}
+	var list TrackerList
+	if err := c.do(ctx, "POST", "/admin/trackers", request, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// do sends a JSON request to an /api/v1 path and decodes the response's
+// data into out
+func (c *Client) do(ctx context.Context, method, path string, request, out interface{}) error {
+	var body io.Reader
+	if request != nil {
+		data, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	if request != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req, out)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL.String()+"/api/v1"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.AdminToken)
+	}
+	return req, nil
+}
+
+func (c *Client) send(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Success   bool            `json:"success"`
+		Data      json.RawMessage `json:"data"`
+		Error     string          `json:"error"`
+		Code      string          `json:"code"`
+		RequestID string          `json:"requestId"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return &Error{StatusCode: resp.StatusCode, Message: "unexpected response: " + err.Error()}
+	}
+	if !response.Success || resp.StatusCode >= 400 {
+		return &Error{
+			StatusCode: resp.StatusCode,
+			Code:       response.Code,
+			Message:    response.Error,
+			RequestID:  response.RequestID,
+		}
+	}
+
+	if out != nil && len(response.Data) > 0 {
+		return json.Unmarshal(response.Data, out)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package apiclient
+
+import (
+	"time"
+
+	"github.com/Nebyat19/Torrent-Streamer/probe"
+	"github.com/Nebyat19/Torrent-Streamer/release"
+)
+
+// Status is the session's current stream, as returned by GET /status
+type Status struct {
+	Status      string     `json:"status"`
+	VideoURL    string     `json:"videoUrl"`
+	Magnet      string     `json:"magnet"`
+	Downloading bool       `json:"downloading"`
+	Progress    float64    `json:"progress"`
+	FileSize    int64      `json:"fileSize"`
+	FileType    string     `json:"fileType"`
+	Subtitles   []Subtitle `json:"subtitles"`
+
+	ResumePosition float64 `json:"resumePosition,omitempty"`
+	PlaylistIndex  int     `json:"playlistIndex"`
+	PlaylistLength int     `json:"playlistLength"`
+
+	Title   string        `json:"title,omitempty"`
+	Release *release.Info `json:"release,omitempty"`
+
+	Media      *probe.Info `json:"media,omitempty"`
+	MediaError string      `json:"mediaError,omitempty"`
+	Warnings   []string    `json:"warnings,omitempty"`
+
+	AudioTracks []AudioTrack `json:"audioTracks,omitempty"`
+	Profiles    []string     `json:"profiles,omitempty"`
+
+	Poster         string `json:"poster,omitempty"`
+	ThumbnailTrack string `json:"thumbnailTrack,omitempty"`
+
+	Peers *PeerStats `json:"peers,omitempty"`
+
+	// Metadata is set while the torrent's metadata is being fetched
+	Metadata *MetadataProgress `json:"metadata,omitempty"`
+}
+
+// PeerStats counts the swarm: connected peers, connected seeders, and every
+// peer known from trackers, DHT and PEX
+type PeerStats struct {
+	Active  int `json:"active"`
+	Seeders int `json:"seeders"`
+	Known   int `json:"known"`
+}
+
+// MetadataProgress follows the server's attempts to fetch metadata. Elapsed
+// and Deadline are in seconds.
+type MetadataProgress struct {
+	Attempt  int     `json:"attempt"`
+	Attempts int     `json:"attempts"`
+	Elapsed  float64 `json:"elapsed"`
+	Deadline float64 `json:"deadline"`
+	Peers    int     `json:"peers"`
+	Trackers int     `json:"trackers"`
+}
+
+type Subtitle struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Lang  string `json:"lang"`
+	Group string `json:"group,omitempty"`
+}
+
+type AudioTrack struct {
+	Index    int    `json:"index"`
+	Language string `json:"language,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Codec    string `json:"codec"`
+	Channels int    `json:"channels,omitempty"`
+	Default  bool   `json:"default"`
+	URL      string `json:"url,omitempty"`
+}
+
+// Progress is the download state of the selected file; Status is "idle",
+// "downloading" or "completed"
+type Progress struct {
+	Progress float64 `json:"progress"`
+	Status   string  `json:"status"`
+}
+
+type PlaylistItem struct {
+	Index   int          `json:"index"`
+	Name    string       `json:"name"`
+	Title   string       `json:"title"`
+	Path    string       `json:"path"`
+	Size    int64        `json:"size"`
+	Season  int          `json:"season,omitempty"`
+	Episode int          `json:"episode,omitempty"`
+	Current bool         `json:"current"`
+	Release release.Info `json:"release"`
+}
+
+type HistoryEntry struct {
+	InfoHash  string    `json:"infoHash"`
+	FilePath  string    `json:"filePath"`
+	Title     string    `json:"title"`
+	Magnet    string    `json:"magnet,omitempty"`
+	Position  float64   `json:"position"`
+	Duration  float64   `json:"duration,omitempty"`
+	Completed bool      `json:"completed"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Thumbnail string    `json:"thumbnail,omitempty"`
+}
+
+// PieceMap describes which parts of the selected file are on disk
+type PieceMap struct {
+	FileSize        int64       `json:"fileSize"`
+	PieceLength     int64       `json:"pieceLength"`
+	PieceCount      int         `json:"pieceCount"`
+	PiecesCompleted int         `json:"piecesCompleted"`
+	Ranges          []ByteRange `json:"ranges"`
+	Duration        float64     `json:"duration,omitempty"`
+	TimeRanges      []TimeRange `json:"timeRanges,omitempty"`
+}
+
+// ByteRange is a half-open [Start, End) span of bytes
+type ByteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// TimeRange is a half-open [Start, End) span of playback time in seconds
+type TimeRange struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// Download states
+const (
+	DownloadMetadata    = "metadata"
+	DownloadDownloading = "downloading"
+	DownloadMoving      = "moving"
+	DownloadCompleted   = "completed"
+	DownloadFailed      = "failed"
+)
+
+// DownloadJob is a torrent being downloaded into the server's library
+type DownloadJob struct {
+	ID             string     `json:"id"`
+	InfoHash       string     `json:"infoHash"`
+	Name           string     `json:"name"`
+	Magnet         string     `json:"magnet"`
+	Files          []string   `json:"files,omitempty"`
+	Priority       string     `json:"priority"`
+	State          string     `json:"state"`
+	Error          string     `json:"error,omitempty"`
+	BytesCompleted int64      `json:"bytesCompleted"`
+	BytesTotal     int64      `json:"bytesTotal"`
+	Progress       float64    `json:"progress"`
+	Destinations   []string   `json:"destinations,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	CompletedAt    *time.Time `json:"completedAt,omitempty"`
+}
+
+// Queue priorities, highest first
+const (
+	PriorityStreaming  = "streaming"
+	PriorityUser       = "user"
+	PriorityBackground = "background"
+)
+
+// Queue entry states
+const (
+	QueueMetadata    = "metadata"
+	QueueDownloading = "downloading"
+	QueueQueued      = "queued"
+	QueuePaused      = "paused"
+)
+
+// QueueStatus lists the torrents in the server's client in scheduling order
+type QueueStatus struct {
+	MaxActive          int          `json:"maxActive"`
+	MaxMetadataFetches int          `json:"maxMetadataFetches"`
+	Entries            []QueueEntry `json:"entries"`
+}
+
+type QueueEntry struct {
+	Position       int    `json:"position"`
+	InfoHash       string `json:"infoHash"`
+	Name           string `json:"name"`
+	Priority       string `json:"priority"`
+	State          string `json:"state"`
+	BytesCompleted int64  `json:"bytesCompleted"`
+	BytesTotal     int64  `json:"bytesTotal"`
+	Peers          int    `json:"peers"`
+}
+
+// Tracker states
+const (
+	TrackerOK          = "ok"
+	TrackerFailed      = "error"
+	TrackerUnsupported = "unsupported"
+)
+
+// TrackerStatus is a tracker of a torrent and the result of its last scrape
+type TrackerStatus struct {
+	URL       string     `json:"url"`
+	Tier      int        `json:"tier"`
+	Status    string     `json:"status"`
+	Seeders   int        `json:"seeders"`
+	Leechers  int        `json:"leechers"`
+	Completed int        `json:"completed"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+}
+
+// TrackerList is the server's default tracker list
+type TrackerList struct {
+	Trackers []string  `json:"trackers"`
+	File     string    `json:"file"`
+	URL      string    `json:"url,omitempty"`
+	Append   bool      `json:"append"`
+	LoadedAt time.Time `json:"loadedAt"`
+}
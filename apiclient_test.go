@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Nebyat19/Torrent-Streamer/apiclient"
+	"github.com/Nebyat19/Torrent-Streamer/probe"
+	"github.com/Nebyat19/Torrent-Streamer/release"
+)
+
+// apiTypes pairs the structs handlers send with their apiclient copies and
+// the OpenAPI schema describing them
+var apiTypes = []struct {
+	schema string
+	server any
+	client any
+}{
+	{"StreamStatus", &StreamStatus{}, &apiclient.Status{}},
+	{"PeerStats", &PeerStats{}, &apiclient.PeerStats{}},
+	{"MetadataProgress", &MetadataProgress{}, &apiclient.MetadataProgress{}},
+	{"Subtitle", &Subtitle{}, &apiclient.Subtitle{}},
+	{"AudioTrack", &AudioTrack{}, &apiclient.AudioTrack{}},
+	{"PlaylistItem", &PlaylistItem{}, &apiclient.PlaylistItem{}},
+	{"HistoryEntry", &HistoryEntry{}, &apiclient.HistoryEntry{}},
+	{"PieceMap", &PieceMap{}, &apiclient.PieceMap{}},
+	{"ByteRange", &ByteRange{}, &apiclient.ByteRange{}},
+	{"TimeRange", &TimeRange{}, &apiclient.TimeRange{}},
+	{"DownloadJob", &DownloadJob{}, &apiclient.DownloadJob{}},
+	{"QueueStatus", &QueueStatus{}, &apiclient.QueueStatus{}},
+	{"QueueEntry", &QueueEntry{}, &apiclient.QueueEntry{}},
+	{"TrackerStatus", &TrackerStatus{}, &apiclient.TrackerStatus{}},
+	{"TrackerList", &TrackerList{}, &apiclient.TrackerList{}},
+	{"ReleaseInfo", &release.Info{}, &release.Info{}},
+	{"MediaInfo", &probe.Info{}, &probe.Info{}},
+	{"Track", &probe.Track{}, &probe.Track{}},
+}
+
+// fill sets every exported field to a non-zero value so omitempty fields
+// are sent too
+func fill(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem())
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			v.Set(reflect.ValueOf(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)))
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				fill(v.Field(i))
+			}
+		}
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fill(v.Index(0))
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		key := reflect.New(v.Type().Key()).Elem()
+		elem := reflect.New(v.Type().Elem()).Elem()
+		fill(key)
+		fill(elem)
+		v.SetMapIndex(key, elem)
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	}
+}
+
+// filled returns a fresh value of the type ptr points to, filled
+func filled(ptr any) any {
+	v := reflect.New(reflect.TypeOf(ptr).Elem())
+	fill(v.Elem())
+	if job, ok := v.Interface().(*DownloadJob); ok {
+		public := job.public()
+		return &public
+	}
+	return v.Interface()
+}
+
+// roundTrip sends from through to's type and back, failing on any field
+// that only one side knows
+func roundTrip(t *testing.T, from, to any) {
+	t.Helper()
+	data, err := json.Marshal(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	decoded := reflect.New(reflect.TypeOf(to).Elem()).Interface()
+	if err := dec.Decode(decoded); err != nil {
+		t.Fatalf("decoding %T into %T: %v", from, to, err)
+	}
+	again, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want, got any
+	json.Unmarshal(data, &want)
+	json.Unmarshal(again, &got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%T through %T\n got %s\nwant %s", from, to, again, data)
+	}
+}
+
+func TestClientTypesMatchServer(t *testing.T) {
+	for _, tt := range apiTypes {
+		t.Run(tt.schema, func(t *testing.T) {
+			roundTrip(t, filled(tt.server), tt.client)
+			roundTrip(t, filled(tt.client), tt.server)
+		})
+	}
+}
+
+func TestSpecSchemasMatchServer(t *testing.T) {
+	var spec struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(apiclient.Spec, &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range apiTypes {
+		t.Run(tt.schema, func(t *testing.T) {
+			schema, ok := spec.Components.Schemas[tt.schema]
+			if !ok {
+				t.Fatalf("openapi.json has no schema %s", tt.schema)
+			}
+			data, _ := json.Marshal(filled(tt.server))
+			var fields map[string]json.RawMessage
+			json.Unmarshal(data, &fields)
+
+			if got, want := keys(schema.Properties), keys(fields); !reflect.DeepEqual(got, want) {
+				t.Errorf("schema properties\n got %v\nwant %v", got, want)
+			}
+		})
+	}
+}
+
+func keys(m map[string]json.RawMessage) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
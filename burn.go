@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asticode/go-astisub"
+	"github.com/google/uuid"
+)
+
+var (
+	errUnsupportedSubtitle = errors.New("unsupported subtitle format")
+	errNoTorrentInfo       = errors.New("session has no torrent metadata")
+)
+
+// parseSubtitles reads a subtitle file in any format the player offers
+func parseSubtitles(r io.Reader, ext string) (*astisub.Subtitles, error) {
+	switch strings.ToLower(ext) {
+	case ".srt":
+		return astisub.ReadFromSRT(r)
+	case ".ass", ".ssa":
+		return astisub.ReadFromSSA(r)
+	case ".vtt":
+		return astisub.ReadFromWebVTT(r)
+	}
+	return nil, errUnsupportedSubtitle
+}
+
+// findSubtitle picks an entry of session.Subtitles by index or name
+func findSubtitle(session *UserSession, param string) (Subtitle, bool) {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	if n, err := strconv.Atoi(param); err == nil {
+		if n >= 0 && n < len(session.Subtitles) {
+			return session.Subtitles[n], true
+		}
+		return Subtitle{}, false
+	}
+	for _, sub := range session.Subtitles {
+		if sub.Name == param {
+			return sub, true
+		}
+	}
+	return Subtitle{}, false
+}
+
+// openSubtitle opens a subtitle's source, which is either a file inside the
+// torrent or one the user uploaded.
+func openSubtitle(session *UserSession, sub Subtitle) (io.ReadCloser, error) {
+	if name, ok := strings.CutPrefix(sub.Path, "/subtitles/"); ok {
+		return os.Open(filepath.Join("subtitles", filepath.Base(name)))
+	}
+
+	u, err := url.Parse(sub.Path)
+	if err != nil {
+		return nil, err
+	}
+	filePath := u.Query().Get("file")
+
+	sessionLock.Lock()
+	t := session.Torrent
+	sessionLock.Unlock()
+	if t == nil || t.Info() == nil {
+		return nil, errNoTorrentInfo
+	}
+	for _, f := range t.Files() {
+		if f.Path() == filePath {
+			return fileReader(f), nil
+		}
+	}
+	return nil, fmt.Errorf("subtitle file %s not found", filePath)
+}
+
+// prepareBurnSubtitle writes the subtitle as a temporary ASS file for
+// ffmpeg's subtitles filter. ASS input keeps its styles; SRT and WebVTT get
+// the default style. Cues are shifted so they line up when output starts
+// at start seconds. The caller removes the returned file; an empty path
+// means no cues remain after start.
+func prepareBurnSubtitle(session *UserSession, sub Subtitle, start float64) (string, error) {
+	src, err := openSubtitle(session, sub)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	ext := filepath.Ext(sub.Name)
+	subs, err := parseSubtitles(src, ext)
+	if err != nil {
+		subtitleConversionErrors.Inc(strings.ToLower(ext))
+		return "", fmt.Errorf("parsing %s: %w", sub.Name, err)
+	}
+	if start > 0 {
+		subs.Add(-time.Duration(start * float64(time.Second)))
+	}
+	if len(subs.Items) == 0 {
+		// Nothing left to show from this point on
+		return "", nil
+	}
+	// SRT and WebVTT carry no script info, which the SSA writer requires
+	if subs.Metadata == nil {
+		subs.Metadata = &astisub.Metadata{}
+	}
+	subs.Metadata.SSAScriptType = "v4.00+"
+
+	path := filepath.Join(os.TempDir(), "ts-burn-"+uuid.New().String()+".ass")
+	out, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := subs.WriteToSSA(out); err != nil {
+		out.Close()
+		os.Remove(path)
+		subtitleConversionErrors.Inc(strings.ToLower(ext))
+		return "", fmt.Errorf("converting %s: %w", sub.Name, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// subtitlesFilter builds an ffmpeg subtitles filter for a file path,
+// escaping the characters filter graphs treat specially.
+func subtitlesFilter(path string) string {
+	path = filepath.ToSlash(path)
+	escaped := strings.NewReplacer(`\`, `\\`, `:`, `\:`, `'`, `\'`, `,`, `\,`, `[`, `\[`, `]`, `\]`, `;`, `\;`).Replace(path)
+	return "subtitles=filename=" + escaped
+}
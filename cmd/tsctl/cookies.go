@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// cookieStore keeps the server's session cookies between runs, so that
+// consecutive commands act on the same ts_session_id. Cookies are stored
+// per server URL.
+type cookieStore struct {
+	path string
+	jar  *cookiejar.Jar
+	url  *url.URL
+}
+
+type savedCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func defaultCookiePath() string {
+	if path := os.Getenv("TSCTL_COOKIES"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "tsctl", "cookies.json")
+}
+
+func loadCookies(path string, server *url.URL) (*cookieStore, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	store := &cookieStore{path: path, jar: jar, url: server}
+
+	saved, err := store.read()
+	if err != nil {
+		return nil, err
+	}
+	var cookies []*http.Cookie
+	for _, c := range saved[server.String()] {
+		cookies = append(cookies, &http.Cookie{Name: c.Name, Value: c.Value, Path: "/"})
+	}
+	jar.SetCookies(server, cookies)
+	return store, nil
+}
+
+func (s *cookieStore) read() (map[string][]savedCookie, error) {
+	saved := make(map[string][]savedCookie)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return saved, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// save writes the jar's current cookies for the server, dropping any the
+// server expired, such as after a session reset
+func (s *cookieStore) save() error {
+	saved, err := s.read()
+	if err != nil {
+		// A corrupt file is replaced rather than blocking every command
+		saved = make(map[string][]savedCookie)
+	}
+
+	var cookies []savedCookie
+	for _, c := range s.jar.Cookies(s.url) {
+		cookies = append(cookies, savedCookie{Name: c.Name, Value: c.Value})
+	}
+	if len(cookies) == 0 {
+		delete(saved, s.url.String())
+	} else {
+		saved[s.url.String()] = cookies
+	}
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	// The session ID is a bearer credential for the stream
+	return os.WriteFile(s.path, data, 0600)
+}
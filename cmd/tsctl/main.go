@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Nebyat19/Torrent-Streamer/apiclient"
+)
+
+const usage = `Usage: tsctl [flags] <command> [arguments]
+
+Commands:
+  add [-wait 2m] <magnet | file.torrent>  start streaming and wait for the video
+  files                                  list the torrent's video files
+  select <index>                         switch to a file from the list
+  url                                    print the video URL
+  play [-player mpv|vlc]                 open the video in a player
+  status [-watch]                        show progress and peers
+  subtitle <file>                        upload a subtitle file
+  stop                                   stop streaming, also while fetching metadata
+  reset                                  drop the torrent and end the session
+
+Flags:
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	server := flag.String("server", envOr("TSCTL_SERVER", "http://localhost:8080"), "streamer URL (TSCTL_SERVER)")
+	cookiePath := flag.String("cookies", defaultCookiePath(), "file keeping the session cookie between runs (TSCTL_COOKIES)")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	baseURL, err := url.Parse(strings.TrimSuffix(*server, "/"))
+	if err != nil {
+		fatal(err)
+	}
+	cookies, err := loadCookies(*cookiePath, baseURL)
+	if err != nil {
+		fatal(fmt.Errorf("reading %s: %w", *cookiePath, err))
+	}
+	c, err := apiclient.New(baseURL.String(), &http.Client{Jar: cookies.jar})
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err = run(ctx, c, flag.Arg(0), flag.Args()[1:])
+	stop()
+
+	// Save even after a failure: the server may have issued a session
+	if serr := cookies.save(); serr != nil {
+		fmt.Fprintf(os.Stderr, "tsctl: saving cookies: %v\n", serr)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func run(ctx context.Context, c *apiclient.Client, command string, args []string) error {
+	switch command {
+	case "add":
+		return cmdAdd(ctx, c, args)
+	case "files", "ls":
+		return cmdFiles(ctx, c)
+	case "select":
+		return cmdSelect(ctx, c, args)
+	case "url":
+		return cmdURL(ctx, c)
+	case "play":
+		return cmdPlay(ctx, c, args)
+	case "status":
+		return cmdStatus(ctx, c, args)
+	case "subtitle":
+		return cmdSubtitle(ctx, c, args)
+	case "stop":
+		return cmdStop(ctx, c)
+	case "reset":
+		return cmdReset(ctx, c)
+	case "help":
+		flag.CommandLine.SetOutput(os.Stdout)
+		flag.Usage()
+		return nil
+	}
+	return fmt.Errorf("unknown command %q; run tsctl help", command)
+}
+
+func cmdAdd(ctx context.Context, c *apiclient.Client, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	wait := fs.Duration("wait", 2*time.Minute, "how long to wait for the video; 0 returns right away")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: tsctl add [-wait 2m] <magnet | file.torrent>")
+	}
+
+	source := fs.Arg(0)
+	if strings.HasPrefix(source, "magnet:") {
+		if err := c.Stream(ctx, source); err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		err = c.StreamTorrent(ctx, filepath.Base(source), f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if *wait == 0 {
+		fmt.Println("Stream started")
+		return nil
+	}
+
+	status, err := waitForVideo(ctx, c, *wait)
+	if err != nil {
+		return err
+	}
+	fmt.Println(displayTitle(status))
+	fmt.Println(c.URL(status.VideoURL))
+	return nil
+}
+
+// waitForVideo polls until the server has found a video file in the torrent
+func waitForVideo(ctx context.Context, c *apiclient.Client, timeout time.Duration) (*apiclient.Status, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	last := ""
+	for {
+		status, err := c.Status(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("no video after %s, the server keeps trying: %s", timeout, last)
+			}
+			return nil, err
+		}
+		if status.VideoURL != "" {
+			return status, nil
+		}
+		if strings.HasPrefix(status.Status, "Error") || strings.HasPrefix(status.Status, "No ") {
+			return nil, errors.New(status.Status)
+		}
+		if status.Status != last {
+			fmt.Fprintln(os.Stderr, status.Status)
+			last = status.Status
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no video after %s, the server keeps trying: %s", timeout, last)
+		case <-ticker.C:
+		}
+	}
+}
+
+func cmdFiles(ctx context.Context, c *apiclient.Client) error {
+	items, err := c.Playlist(ctx)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return errors.New("no video files; add a torrent first")
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, item := range items {
+		current := " "
+		if item.Current {
+			current = "*"
+		}
+		fmt.Fprintf(tw, "%s %d\t%s\t%s\t%s\n", current, item.Index, item.Title, formatBytes(item.Size), item.Path)
+	}
+	return tw.Flush()
+}
+
+func cmdSelect(ctx context.Context, c *apiclient.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tsctl select <index>")
+	}
+	index, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid index %q", args[0])
+	}
+	items, err := c.Select(ctx, index)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if item.Current {
+			fmt.Println(item.Title)
+		}
+	}
+	return nil
+}
+
+func cmdURL(ctx context.Context, c *apiclient.Client) error {
+	status, err := currentVideo(ctx, c)
+	if err != nil {
+		return err
+	}
+	fmt.Println(c.URL(status.VideoURL))
+	return nil
+}
+
+// players are tried in order when no player is given
+var players = []string{"mpv", "vlc"}
+
+func cmdPlay(ctx context.Context, c *apiclient.Client, args []string) error {
+	fs := flag.NewFlagSet("play", flag.ExitOnError)
+	player := fs.String("player", os.Getenv("TSCTL_PLAYER"), "player to run, mpv or vlc (TSCTL_PLAYER); the first one installed by default")
+	fs.Parse(args)
+
+	status, err := currentVideo(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	if *player == "" {
+		for _, name := range players {
+			if _, err := exec.LookPath(name); err == nil {
+				*player = name
+				break
+			}
+		}
+		if *player == "" {
+			return errors.New("neither mpv nor vlc is installed; use tsctl url with another player")
+		}
+	}
+
+	videoURL := c.URL(status.VideoURL)
+	var playerArgs []string
+	switch filepath.Base(*player) {
+	case "mpv":
+		playerArgs = append(playerArgs, "--force-media-title="+displayTitle(status))
+		if status.ResumePosition > 0 {
+			playerArgs = append(playerArgs, fmt.Sprintf("--start=%.0f", status.ResumePosition))
+		}
+		for _, sub := range status.Subtitles {
+			playerArgs = append(playerArgs, "--sub-file="+c.URL(sub.Path))
+		}
+	case "vlc", "cvlc":
+		playerArgs = append(playerArgs, "--meta-title="+displayTitle(status))
+		if status.ResumePosition > 0 {
+			playerArgs = append(playerArgs, fmt.Sprintf("--start-time=%.0f", status.ResumePosition))
+		}
+	}
+	playerArgs = append(playerArgs, videoURL)
+
+	cmd := exec.CommandContext(ctx, *player, playerArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+func cmdStatus(ctx context.Context, c *apiclient.Client, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	watch := fs.Bool("watch", false, "keep updating progress and peers until the download completes")
+	fs.Parse(args)
+
+	status, err := c.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if !*watch {
+		printStatus(c, status)
+		return nil
+	}
+
+	fmt.Println(displayTitle(status))
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	lastBytes, lastTime := downloadedBytes(status), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return nil
+		case <-ticker.C:
+		}
+
+		if status, err = c.Status(ctx); err != nil {
+			if ctx.Err() != nil {
+				fmt.Println()
+				return nil
+			}
+			return err
+		}
+		now := time.Now()
+		downloaded := downloadedBytes(status)
+		rate := float64(downloaded-lastBytes) / now.Sub(lastTime).Seconds()
+		if rate < 0 {
+			// The playlist moved on to another file
+			rate = 0
+		}
+		lastBytes, lastTime = downloaded, now
+
+		fmt.Printf("\r\033[K%5.1f%% of %s  %s/s  %s", status.Progress, formatBytes(status.FileSize), formatBytes(int64(rate)), formatPeers(status.Peers))
+		if status.VideoURL != "" && !status.Downloading {
+			fmt.Println()
+			return nil
+		}
+	}
+}
+
+func printStatus(c *apiclient.Client, status *apiclient.Status) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if title := displayTitle(status); title != "" {
+		fmt.Fprintf(tw, "Title:\t%s\n", title)
+	}
+	fmt.Fprintf(tw, "Status:\t%s\n", status.Status)
+	if status.VideoURL != "" {
+		fmt.Fprintf(tw, "Progress:\t%.1f%% of %s\n", status.Progress, formatBytes(status.FileSize))
+		if status.PlaylistLength > 1 {
+			fmt.Fprintf(tw, "File:\t%d of %d\n", status.PlaylistIndex+1, status.PlaylistLength)
+		}
+	}
+	if status.Peers != nil {
+		fmt.Fprintf(tw, "Peers:\t%s\n", formatPeers(status.Peers))
+	}
+	for _, sub := range status.Subtitles {
+		fmt.Fprintf(tw, "Subtitle:\t%s\n", sub.Name)
+	}
+	for _, warning := range status.Warnings {
+		fmt.Fprintf(tw, "Warning:\t%s\n", warning)
+	}
+	if status.VideoURL != "" {
+		fmt.Fprintf(tw, "URL:\t%s\n", c.URL(status.VideoURL))
+	}
+	tw.Flush()
+}
+
+func cmdSubtitle(ctx context.Context, c *apiclient.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tsctl subtitle <file>")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := c.UploadSubtitle(ctx, filepath.Base(args[0]), f); err != nil {
+		return err
+	}
+	fmt.Println("Subtitle uploaded")
+	return nil
+}
+
+func cmdStop(ctx context.Context, c *apiclient.Client) error {
+	if err := c.StopStream(ctx); err != nil {
+		return err
+	}
+	fmt.Println("Stream stopped")
+	return nil
+}
+
+func cmdReset(ctx context.Context, c *apiclient.Client) error {
+	if err := c.ResetSession(ctx); err != nil {
+		return err
+	}
+	fmt.Println("Session reset")
+	return nil
+}
+
+func currentVideo(ctx context.Context, c *apiclient.Client) (*apiclient.Status, error) {
+	status, err := c.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if status.VideoURL == "" {
+		return nil, fmt.Errorf("no video yet: %s", status.Status)
+	}
+	return status, nil
+}
+
+func displayTitle(status *apiclient.Status) string {
+	if status.Title != "" {
+		return status.Title
+	}
+	name, _ := strings.CutPrefix(status.Status, "Streaming: ")
+	if name == status.Status {
+		return ""
+	}
+	return name
+}
+
+func downloadedBytes(status *apiclient.Status) int64 {
+	return int64(status.Progress / 100 * float64(status.FileSize))
+}
+
+func formatPeers(peers *apiclient.PeerStats) string {
+	if peers == nil {
+		return "no peers"
+	}
+	return fmt.Sprintf("%d peers (%d seeders, %d known)", peers.Active, peers.Seeders, peers.Known)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "tsctl: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,174 @@
+// Command tsseed seeds a generated fixture torrent from a local client, so
+// the streamer can be exercised end to end without a network: run it, then
+// start the streamer with OFFLINE=true and stream the magnet it prints.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	alog "github.com/anacrolix/log"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const usage = `Usage: tsseed [flags]
+
+Generates a fixture torrent with a video and a subtitle, seeds it and prints
+a magnet link whose x.pe parameter points at the seeder. The video is a short
+ffmpeg test pattern when ffmpeg is installed and filler bytes otherwise.
+
+Flags:
+`
+
+// The fixture's name parses as a movie release, like a real torrent's
+const fixtureName = "Fixture.Movie.2024.720p.WEB.x264-TS"
+
+const fixtureSubtitle = `1
+00:00:00,000 --> 00:00:02,000
+Fixture subtitle
+
+2
+00:00:02,000 --> 00:00:04,000
+Second line
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	dir := flag.String("dir", "", "where to create the fixture (default a temporary directory)")
+	listen := flag.String("listen", "127.0.0.1:42169", "address to seed from")
+	size := flag.Int("size", 8, "size of the filler video in MB")
+	synthetic := flag.Bool("synthetic", false, "write filler bytes even when ffmpeg is installed")
+	torrentFile := flag.String("torrent", "", "also write the .torrent file here")
+	flag.Parse()
+
+	if err := run(*dir, *listen, *size, *synthetic, *torrentFile); err != nil {
+		fmt.Fprintf(os.Stderr, "tsseed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, listen string, size int, synthetic bool, torrentFile string) error {
+	host, portStr, err := net.SplitHostPort(listen)
+	if err != nil {
+		return fmt.Errorf("listen address: %w", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("listen port: %w", err)
+	}
+
+	if dir == "" {
+		if dir, err = os.MkdirTemp("", "tsseed-"); err != nil {
+			return err
+		}
+	}
+	root := filepath.Join(dir, fixtureName)
+	if err := writeFixture(root, size, synthetic); err != nil {
+		return fmt.Errorf("writing fixture: %w", err)
+	}
+
+	info := metainfo.Info{PieceLength: 256 << 10}
+	if err := info.BuildFromFilePath(root); err != nil {
+		return fmt.Errorf("hashing fixture: %w", err)
+	}
+	mi := metainfo.MetaInfo{CreatedBy: "tsseed", CreationDate: time.Now().Unix()}
+	if mi.InfoBytes, err = bencode.Marshal(info); err != nil {
+		return err
+	}
+	if torrentFile != "" {
+		if err := writeMetainfo(&mi, torrentFile); err != nil {
+			return fmt.Errorf("writing %s: %w", torrentFile, err)
+		}
+	}
+
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.DataDir = dir
+	cfg.Seed = true
+	cfg.NoDHT = true
+	cfg.DisableTrackers = true
+	cfg.NoDefaultPortForwarding = true
+	cfg.DisableIPv6 = true
+	cfg.ListenHost = func(string) string { return host }
+	cfg.ListenPort = port
+	cfg.Logger = alog.NewLogger("tsseed").WithFilterLevel(alog.Warning)
+
+	cl, err := torrent.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+
+	t, err := cl.AddTorrent(&mi)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-t.Complete().On():
+	case <-time.After(time.Minute):
+		return errors.New("fixture did not verify")
+	}
+
+	m := mi.Magnet(nil, &info)
+	m.Params = url.Values{"x.pe": {listen}}
+	fmt.Println(m.String())
+	fmt.Fprintf(os.Stderr, "tsseed: seeding %s from %s\n", root, listen)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	return nil
+}
+
+// writeFixture creates the fixture's video and subtitle under root
+func writeFixture(root string, size int, synthetic bool) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	video := filepath.Join(root, fixtureName+".mp4")
+	if err := os.WriteFile(filepath.Join(root, fixtureName+".en.srt"), []byte(fixtureSubtitle), 0644); err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("ffmpeg"); err == nil && !synthetic {
+		cmd := exec.Command("ffmpeg", "-y", "-loglevel", "error",
+			"-f", "lavfi", "-i", "testsrc=duration=10:size=640x360:rate=25",
+			"-f", "lavfi", "-i", "sine=duration=10",
+			"-c:v", "libx264", "-pix_fmt", "yuv420p", "-c:a", "aac",
+			"-movflags", "+faststart", video)
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+
+	// Seeded filler, so the same size gives the same torrent every time
+	data := make([]byte, size<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	return os.WriteFile(video, data, 0644)
+}
+
+func writeMetainfo(mi *metainfo.MetaInfo, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := mi.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/anacrolix/dht/v2"
+	"github.com/anacrolix/dht/v2/krpc"
+	"github.com/anacrolix/torrent"
+)
+
+var (
+	// Private deployments can keep the client off the public swarm
+	// discovery. The torrent library has no Local Service Discovery, so
+	// there is no LSD switch. Offline mode turns the DHT off by default.
+	dhtDisabled = envBool("DISABLE_DHT", offlineMode)
+	pexDisabled = envBool("DISABLE_PEX", false)
+
+	// The routing table is saved here on shutdown so a restart can skip
+	// bootstrapping from scratch
+	dhtNodesFile = envOr("DHT_NODES_FILE", "data/dht-nodes.dat")
+
+	// Bootstrap nodes as host:port, replacing the public routers, e.g. a
+	// local DHT node in tests
+	dhtBootstrap = splitList(os.Getenv("DHT_BOOTSTRAP"))
+)
+
+// configureDHT applies the DHT and PEX settings to a client config
+func configureDHT(cfg *torrent.ClientConfig) {
+	cfg.NoDHT = dhtDisabled
+	cfg.DisablePEX = pexDisabled
+	if len(dhtBootstrap) == 0 {
+		return
+	}
+	cfg.DhtStartingNodes = func(network string) dht.StartingNodesGetter {
+		return func() ([]dht.Addr, error) {
+			return resolveBootstrap(network, dhtBootstrap)
+		}
+	}
+}
+
+func resolveBootstrap(network string, hostPorts []string) ([]dht.Addr, error) {
+	var addrs []dht.Addr
+	var lastErr error
+	for _, hostPort := range hostPorts {
+		ua, err := net.ResolveUDPAddr(network, hostPort)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		addrs = append(addrs, dht.NewAddr(ua))
+	}
+	if len(addrs) == 0 {
+		return nil, lastErr
+	}
+	return addrs, nil
+}
+
+func dhtServers() []*dht.Server {
+	var servers []*dht.Server
+	for _, s := range client.DhtServers() {
+		if w, ok := s.(torrent.AnacrolixDhtServerWrapper); ok {
+			servers = append(servers, w.Server)
+		}
+	}
+	return servers
+}
+
+// loadDHTNodes seeds the routing table with the nodes saved last time
+func loadDHTNodes() {
+	if dhtDisabled {
+		return
+	}
+	nodes, err := dht.ReadNodesFromFile(dhtNodesFile)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		torrentLog.Warn("Could not read DHT nodes from %s: %v", dhtNodesFile, err)
+		return
+	}
+	for _, s := range dhtServers() {
+		for _, n := range nodes {
+			// Servers reject nodes of the other address family
+			s.AddNode(n)
+		}
+	}
+	torrentLog.Info("Loaded %d saved DHT nodes", len(nodes))
+}
+
+// saveDHTNodes writes the routing table for the next start
+func saveDHTNodes() {
+	var nodes []krpc.NodeInfo
+	seen := make(map[string]bool)
+	for _, s := range dhtServers() {
+		for _, n := range s.Nodes() {
+			if addr := n.Addr.String(); !seen[addr] {
+				seen[addr] = true
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	if len(nodes) == 0 {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dhtNodesFile), 0755); err != nil {
+		torrentLog.Error("Could not save DHT nodes: %v", err)
+		return
+	}
+	tmp := dhtNodesFile + ".tmp"
+	if err := dht.WriteNodesToFile(nodes, tmp); err != nil {
+		torrentLog.Error("Could not save DHT nodes: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, dhtNodesFile); err != nil {
+		torrentLog.Error("Could not save DHT nodes: %v", err)
+		return
+	}
+	torrentLog.Info("Saved %d DHT nodes", len(nodes))
+}
@@ -0,0 +1,740 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nebyat19/Torrent-Streamer/logger"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/google/uuid"
+)
+
+const (
+	downloadsFile = "data/downloads.json"
+
+	// Metainfo of downloads is kept so a restart doesn't wait for peers again
+	downloadTorrentsDir = "data/torrents"
+
+	downloadPollInterval = 5 * time.Second
+
+	// downloadLimitRetry is the Retry-After sent to users at their download
+	// limit; a slot frees up whenever one of their downloads finishes
+	downloadLimitRetry = time.Minute
+)
+
+// Download states
+const (
+	downloadMetadata    = "metadata"
+	downloadDownloading = "downloading"
+	downloadMoving      = "moving"
+	downloadCompleted   = "completed"
+	downloadFailed      = "failed"
+)
+
+var (
+	downloadLog = logger.Component("downloads")
+
+	errDownloadExists = errors.New("user is already downloading the torrent")
+	errDownloadLimit  = errors.New("too many unfinished downloads")
+
+	// maxUserDownloads limits each user's unfinished downloads; 0 means no
+	// limit
+	maxUserDownloads = envInt("MAX_USER_DOWNLOADS", 5)
+
+	// Library layout; see libraryPath for the placeholders
+	libraryDir         = envOr("LIBRARY_DIR", "library")
+	libraryMoviePath   = envOr("LIBRARY_MOVIE_PATH", "Movies/{title} ({year})/{file}")
+	libraryEpisodePath = envOr("LIBRARY_EPISODE_PATH", "TV/{title}/Season {season}/{file}")
+)
+
+// DownloadJob fetches a torrent into the library for watching later. It
+// runs independently of sessions and survives restarts.
+type DownloadJob struct {
+	ID       string `json:"id"`
+	InfoHash string `json:"infoHash"`
+	Name     string `json:"name"`
+	Magnet   string `json:"magnet"`
+	// Files limits the download to these paths; empty means every file
+	Files []string `json:"files,omitempty"`
+	// Priority is "user" or "background"; see queuePriority
+	Priority string `json:"priority"`
+	State    string `json:"state"`
+	Error    string `json:"error,omitempty"`
+
+	// UserID is the ts_user_id of whoever added the job; only they and
+	// admins can see or cancel it. It's saved in the jobs file but never
+	// sent to clients, since the cookie's value identifies its owner.
+	UserID string `json:"userId,omitempty"`
+
+	BytesCompleted int64   `json:"bytesCompleted"`
+	BytesTotal     int64   `json:"bytesTotal"`
+	Progress       float64 `json:"progress"`
+
+	// Destinations are the finished files' paths in the library
+	Destinations []string   `json:"destinations,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	CompletedAt  *time.Time `json:"completedAt,omitempty"`
+}
+
+// public copies the job without what clients mustn't see
+func (j *DownloadJob) public() DownloadJob {
+	copied := *j
+	copied.UserID = ""
+	return copied
+}
+
+// downloadTask is the running side of a job
+type downloadTask struct {
+	t      *torrent.Torrent
+	files  []*torrent.File
+	cancel context.CancelFunc
+}
+
+type downloadManager struct {
+	mu    sync.Mutex
+	path  string
+	jobs  []*DownloadJob
+	tasks map[string]*downloadTask
+
+	// Serializes writes of the jobs file
+	saveMu sync.Mutex
+}
+
+var downloads = &downloadManager{path: downloadsFile, tasks: make(map[string]*downloadTask)}
+
+func (m *downloadManager) load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &m.jobs)
+}
+
+func (m *downloadManager) save() {
+	if err := m.write(); err != nil {
+		downloadLog.Error("Could not save downloads: %v", err)
+	}
+}
+
+func (m *downloadManager) write() error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	m.mu.Lock()
+	data, err := json.Marshal(m.jobs)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// find returns a job by ID; callers must hold m.mu
+func (m *downloadManager) find(id string) *DownloadJob {
+	for _, job := range m.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// resume restarts unfinished jobs, e.g. after the server restarted
+func (m *downloadManager) resume() {
+	m.mu.Lock()
+	var pending []*DownloadJob
+	for _, job := range m.jobs {
+		if job.State != downloadCompleted && job.State != downloadFailed && m.tasks[job.ID] == nil {
+			pending = append(pending, job)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, job := range pending {
+		priority, _ := parseDownloadPriority(job.Priority)
+		spec, err := downloadSpec(job)
+		if err == nil {
+			err = m.start(job.ID, spec, priority)
+		}
+		if err != nil {
+			downloadLog.Error("Could not resume download %s: %v", job.Name, err)
+			m.fail(job.ID, err)
+			continue
+		}
+		downloadLog.Info("Resumed download: %s", job.Name)
+	}
+}
+
+func downloadSpec(job *DownloadJob) (*torrent.TorrentSpec, error) {
+	if mi, err := metainfo.LoadFromFile(filepath.Join(downloadTorrentsDir, job.InfoHash+".torrent")); err == nil {
+		return torrent.TorrentSpecFromMetaInfoErr(mi)
+	}
+	return torrent.TorrentSpecFromMagnetUri(job.Magnet)
+}
+
+// stop cancels every running job without touching their state, for when
+// the torrent client goes away
+func (m *downloadManager) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, task := range m.tasks {
+		task.cancel()
+		delete(m.tasks, id)
+	}
+}
+
+func (m *downloadManager) add(userID string, spec *torrent.TorrentSpec, magnet string, files []string, priority queuePriority) (*DownloadJob, error) {
+	infoHash := spec.InfoHash.HexString()
+
+	m.mu.Lock()
+	if maxUserDownloads > 0 && m.unfinished(userID) >= maxUserDownloads {
+		m.mu.Unlock()
+		return nil, errDownloadLimit
+	}
+	for i, job := range m.jobs {
+		// Other users' downloads of the same torrent are theirs to see
+		if job.InfoHash != infoHash || job.UserID != userID {
+			continue
+		}
+		if job.State != downloadFailed {
+			m.mu.Unlock()
+			return nil, errDownloadExists
+		}
+		// Retrying a failed download replaces it
+		m.jobs = append(m.jobs[:i], m.jobs[i+1:]...)
+		break
+	}
+	job := &DownloadJob{
+		ID:        uuid.New().String(),
+		InfoHash:  infoHash,
+		UserID:    userID,
+		Name:      spec.DisplayName,
+		Magnet:    magnet,
+		Files:     files,
+		Priority:  priority.String(),
+		State:     downloadMetadata,
+		CreatedAt: time.Now(),
+	}
+	if job.Name == "" {
+		job.Name = infoHash
+	}
+	m.jobs = append(m.jobs, job)
+	copied := job.public()
+	m.mu.Unlock()
+
+	if err := m.start(job.ID, spec, priority); err != nil {
+		m.mu.Lock()
+		m.removeJob(job.ID)
+		m.mu.Unlock()
+		return nil, err
+	}
+	m.save()
+	downloadLog.Info("Queued download: %s", job.Name, logger.InfoHash(infoHash))
+	return &copied, nil
+}
+
+func (m *downloadManager) start(id string, spec *torrent.TorrentSpec, priority queuePriority) error {
+	withDefaultTrackers(spec)
+	withPeers(spec)
+	t, _, err := client.AddTorrentSpec(spec)
+	if err != nil {
+		return err
+	}
+	queue.hold(t, downloadHolder(id), priority)
+	ctx, cancel := context.WithCancel(appContext)
+
+	m.mu.Lock()
+	m.tasks[id] = &downloadTask{t: t, cancel: cancel}
+	m.mu.Unlock()
+
+	go func() {
+		defer recoverFromPanic("download")
+		m.run(ctx, id, t)
+	}()
+	return nil
+}
+
+func (m *downloadManager) run(ctx context.Context, id string, t *torrent.Torrent) {
+	log := downloadLog.With(logger.InfoHash(t.InfoHash().HexString()))
+
+	select {
+	case <-t.GotInfo():
+	case <-ctx.Done():
+		return
+	}
+	if err := saveMetainfo(t); err != nil {
+		log.Warn("Could not keep metainfo of %s: %v", t.Name(), err)
+	}
+
+	m.mu.Lock()
+	job, task := m.find(id), m.tasks[id]
+	if job == nil || task == nil {
+		m.mu.Unlock()
+		return
+	}
+	files, err := selectDownloadFiles(t, job.Files)
+	if err != nil {
+		m.mu.Unlock()
+		m.fail(id, err)
+		return
+	}
+	task.files = files
+	job.Name = t.Name()
+	job.State = downloadDownloading
+	job.BytesTotal = 0
+	for _, f := range files {
+		job.BytesTotal += f.Length()
+	}
+	m.mu.Unlock()
+	m.save()
+
+	log.Info("Downloading %d files of %s", len(files), t.Name())
+	for _, f := range files {
+		f.Download()
+	}
+
+	ticker := time.NewTicker(downloadPollInterval)
+	defer ticker.Stop()
+	for !filesComplete(files) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+
+	m.setState(id, downloadMoving)
+	destinations, err := moveToLibrary(files)
+	if err != nil {
+		log.Error("Could not move %s into the library: %v", t.Name(), err)
+		m.fail(id, err)
+		return
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	if job := m.find(id); job != nil {
+		job.State = downloadCompleted
+		job.Destinations = destinations
+		job.CompletedAt = &now
+		job.BytesCompleted = job.BytesTotal
+		job.Progress = 100
+	}
+	m.mu.Unlock()
+	m.save()
+	log.Info("Download complete: %s", t.Name())
+
+	// Nothing needs the copies in the data directory once the torrent is gone
+	if m.finish(id) {
+		for _, f := range files {
+			os.Remove(filepath.Join(dataDir, f.Path()))
+		}
+	}
+}
+
+func (m *downloadManager) setState(id, state string) {
+	m.mu.Lock()
+	if job := m.find(id); job != nil {
+		job.State = state
+	}
+	m.mu.Unlock()
+	m.save()
+}
+
+func (m *downloadManager) fail(id string, err error) {
+	m.mu.Lock()
+	if job := m.find(id); job != nil {
+		job.State = downloadFailed
+		job.Error = err.Error()
+	}
+	m.mu.Unlock()
+	m.save()
+	m.finish(id)
+}
+
+// finish stops a job's task and lets go of its torrent, reporting whether
+// the torrent was dropped
+func (m *downloadManager) finish(id string) bool {
+	m.mu.Lock()
+	task := m.tasks[id]
+	delete(m.tasks, id)
+	m.mu.Unlock()
+	if task == nil {
+		return false
+	}
+	task.cancel()
+	return queue.release(task.t, downloadHolder(id))
+}
+
+// unfinished counts the user's jobs still running; callers must hold m.mu
+func (m *downloadManager) unfinished(userID string) int {
+	n := 0
+	for _, job := range m.jobs {
+		if job.UserID == userID && job.State != downloadCompleted && job.State != downloadFailed {
+			n++
+		}
+	}
+	return n
+}
+
+// jobIDs returns the IDs of userID's jobs
+func (m *downloadManager) jobIDs(userID string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []string
+	for _, job := range m.jobs {
+		if job.UserID == userID {
+			ids = append(ids, job.ID)
+		}
+	}
+	return ids
+}
+
+// cancel stops and forgets a job of userID, or of anyone when userID is
+// empty. Files already moved into the library stay there.
+func (m *downloadManager) cancel(id, userID string) bool {
+	m.mu.Lock()
+	found := false
+	if job := m.find(id); job != nil && (userID == "" || job.UserID == userID) {
+		found = m.removeJob(id)
+	}
+	m.mu.Unlock()
+	if !found {
+		return false
+	}
+	m.finish(id)
+	m.save()
+	return true
+}
+
+// removeJob deletes a job from the list; callers must hold m.mu
+func (m *downloadManager) removeJob(id string) bool {
+	for i, job := range m.jobs {
+		if job.ID == id {
+			m.jobs = append(m.jobs[:i], m.jobs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// list returns a snapshot of userID's jobs, or everyone's when userID is
+// empty, with current progress
+func (m *downloadManager) list(userID string) []DownloadJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]DownloadJob, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		if userID != "" && job.UserID != userID {
+			continue
+		}
+		copied := job.public()
+		if task := m.tasks[job.ID]; task != nil && len(task.files) > 0 {
+			copied.BytesCompleted = 0
+			for _, f := range task.files {
+				copied.BytesCompleted += f.BytesCompleted()
+			}
+		}
+		if copied.BytesTotal > 0 {
+			copied.Progress = float64(copied.BytesCompleted) / float64(copied.BytesTotal) * 100
+		}
+		jobs = append(jobs, copied)
+	}
+	return jobs
+}
+
+func saveMetainfo(t *torrent.Torrent) error {
+	mi := t.Metainfo()
+	if err := os.MkdirAll(downloadTorrentsDir, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(downloadTorrentsDir, t.InfoHash().HexString()+".torrent"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return mi.Write(f)
+}
+
+func selectDownloadFiles(t *torrent.Torrent, paths []string) ([]*torrent.File, error) {
+	if len(paths) == 0 {
+		return t.Files(), nil
+	}
+	byPath := make(map[string]*torrent.File)
+	for _, f := range t.Files() {
+		byPath[f.Path()] = f
+	}
+	files := make([]*torrent.File, 0, len(paths))
+	for _, p := range paths {
+		f, ok := byPath[p]
+		if !ok {
+			return nil, fmt.Errorf("no file %q in torrent", p)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func filesComplete(files []*torrent.File) bool {
+	for _, f := range files {
+		if f.BytesCompleted() < f.Length() {
+			return false
+		}
+	}
+	return true
+}
+
+// moveToLibrary places finished files at their library paths. A hard link
+// is instant when the library shares a filesystem with the data directory;
+// otherwise the data is copied out of the torrent.
+func moveToLibrary(files []*torrent.File) ([]string, error) {
+	destinations := make([]string, 0, len(files))
+	for _, f := range files {
+		src := filepath.Join(dataDir, f.Path())
+		dst, present, err := libraryDestination(filepath.Join(libraryDir, libraryPath(f)), src)
+		if err != nil {
+			return nil, err
+		}
+		if !present {
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return nil, err
+			}
+			if err := os.Link(src, dst); err != nil {
+				if err := copyTorrentFile(f, dst); err != nil {
+					return nil, err
+				}
+			}
+		}
+		destinations = append(destinations, dst)
+	}
+	return destinations, nil
+}
+
+// libraryDestination finds a free name for a file meant for path, adding
+// " (2)", " (3)" and so on when different releases map to the same path.
+// present is set when path is already a link to src.
+func libraryDestination(path, src string) (dst string, present bool, err error) {
+	srcInfo, _ := os.Stat(src)
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for n := 1; ; n++ {
+		dst = path
+		if n > 1 {
+			dst = fmt.Sprintf("%s (%d)%s", base, n, ext)
+		}
+		info, err := os.Stat(dst)
+		if errors.Is(err, os.ErrNotExist) {
+			return dst, false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		if srcInfo != nil && os.SameFile(info, srcInfo) {
+			return dst, true, nil
+		}
+	}
+}
+
+func copyTorrentFile(f *torrent.File, dst string) error {
+	reader := fileReader(f)
+	defer reader.Close()
+
+	tmp := dst + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// libraryPath renders a file's place in the library from
+// LIBRARY_MOVIE_PATH or, for episodes, LIBRARY_EPISODE_PATH. Placeholders
+// are {title}, {year}, {season}, {episode}, {resolution}, {group},
+// {torrent} and {file}, the file's own name; empty ones drop out along with
+// brackets around them.
+func libraryPath(f *torrent.File) string {
+	info := fileRelease(f)
+	template := libraryMoviePath
+	if info.Season > 0 || info.Episode > 0 {
+		template = libraryEpisodePath
+	}
+
+	title := info.Title
+	if title == "" {
+		title = f.Torrent().Name()
+	}
+	number := func(n int) string {
+		if n == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%02d", n)
+	}
+	year := ""
+	if info.Year > 0 {
+		year = fmt.Sprint(info.Year)
+	}
+
+	r := strings.NewReplacer(
+		"{title}", pathSafe(title),
+		"{year}", year,
+		"{season}", number(info.Season),
+		"{episode}", number(info.Episode),
+		"{resolution}", pathSafe(info.Resolution),
+		"{group}", pathSafe(info.Group),
+		"{torrent}", pathSafe(f.Torrent().Name()),
+		"{file}", pathSafe(filepath.Base(f.Path())),
+	)
+
+	var segments []string
+	for _, segment := range strings.Split(r.Replace(template), "/") {
+		for _, empty := range []string{"()", "[]", "{}"} {
+			segment = strings.ReplaceAll(segment, empty, "")
+		}
+		segment = strings.Join(strings.Fields(segment), " ")
+		segment = strings.Trim(segment, " .-")
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 {
+		return pathSafe(filepath.Base(f.Path()))
+	}
+	return filepath.Join(segments...)
+}
+
+// pathSafe makes a value usable as part of a single path segment
+func pathSafe(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 0x20 {
+			return -1
+		}
+		return r
+	}, s)
+	if s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
+
+// downloadOwner is the user whose downloads a request may see and cancel;
+// empty for admins, who manage every download
+func downloadOwner(w http.ResponseWriter, r *http.Request) string {
+	if isAdminRequest(r) {
+		return ""
+	}
+	return getUserID(w, r)
+}
+
+func apiDownloadsHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, APIResponse{Success: true, Data: downloads.list(downloadOwner(w, r))})
+}
+
+// apiDownloadAddHandler queues a magnet link (JSON) or .torrent file
+// (multipart) for download into the library, optionally limited to files
+func apiDownloadAddHandler(w http.ResponseWriter, r *http.Request) {
+	var spec *torrent.TorrentSpec
+	var magnet string
+	var files []string
+	var priorityName string
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		var ok bool
+		if spec, magnet, ok = readTorrentUpload(w, r); !ok {
+			return
+		}
+		files = r.MultipartForm.Value["files"]
+		priorityName = r.FormValue("priority")
+	} else {
+		var requestData struct {
+			Magnet   string   `json:"magnet"`
+			Files    []string `json:"files"`
+			Priority string   `json:"priority"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+			respondError(w, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON")
+			return
+		}
+		if !strings.HasPrefix(requestData.Magnet, "magnet:?") {
+			respondError(w, http.StatusBadRequest, codeInvalidMagnet, "Invalid magnet link format")
+			return
+		}
+		var err error
+		if spec, err = torrent.TorrentSpecFromMagnetUri(requestData.Magnet); err != nil {
+			respondError(w, http.StatusBadRequest, codeInvalidMagnet, "Invalid magnet link: "+err.Error())
+			return
+		}
+		magnet, files = requestData.Magnet, requestData.Files
+		priorityName = requestData.Priority
+	}
+
+	priority, ok := parseDownloadPriority(priorityName)
+	if !ok {
+		respondError(w, http.StatusBadRequest, codeInvalidRequest, `Priority must be "user" or "background"`)
+		return
+	}
+
+	job, err := downloads.add(getUserID(w, r), spec, magnet, files, priority)
+	if errors.Is(err, errDownloadExists) {
+		respondError(w, http.StatusConflict, codeAlreadyExists, "You are already downloading this torrent")
+		return
+	}
+	if errors.Is(err, errDownloadLimit) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(downloadLimitRetry.Seconds())))
+		respondError(w, http.StatusTooManyRequests, codeTooManyRequests, fmt.Sprintf("Too many unfinished downloads, the limit is %d", maxUserDownloads))
+		return
+	}
+	if err != nil {
+		downloadLog.Error("Could not add download: %v", err)
+		respondError(w, http.StatusInternalServerError, codeInternal, "Could not add download")
+		return
+	}
+	respondJSON(w, APIResponse{Success: true, Data: job})
+}
+
+func apiDownloadDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if !downloads.cancel(r.URL.Query().Get("id"), downloadOwner(w, r)) {
+		respondError(w, http.StatusNotFound, codeNotFound, "No such download")
+		return
+	}
+	respondJSON(w, APIResponse{Success: true, Message: "Download removed"})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// envOr returns an environment variable, or fallback when it is unset
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// envInt parses a non-negative integer environment variable
+func envInt(key string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n < 0 {
+		return fallback
+	}
+	return n
+}
+
+// envDuration parses a positive duration environment variable such as "90s"
+func envDuration(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+// envBool parses a boolean environment variable such as "true" or "0"
+func envBool(key string, fallback bool) bool {
+	b, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return b
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/anacrolix/dht/v2"
+)
+
+// HealthCheck is the outcome of a single probe check
+type HealthCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// HealthReport is returned by /livez and /readyz
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// lastHeartbeat is bumped by the health monitor loop; a stale value means
+// the background loops are wedged.
+var lastHeartbeat atomic.Int64
+
+const heartbeatStaleAfter = 2 * time.Minute
+
+func recordHeartbeat() {
+	lastHeartbeat.Store(time.Now().UnixNano())
+}
+
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+	respondHealth(w, []HealthCheck{
+		checkHeartbeat(),
+		checkClientOpen(),
+	})
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	respondHealth(w, []HealthCheck{
+		checkClientOpen(),
+		checkListening(),
+		checkDHT(),
+		checkDataDirWritable(),
+		checkFreeSpace(),
+	})
+}
+
+func respondHealth(w http.ResponseWriter, checks []HealthCheck) {
+	report := HealthReport{Status: "ok", Checks: checks}
+	code := http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			report.Status = "fail"
+			code = http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
+
+func checkHeartbeat() HealthCheck {
+	c := HealthCheck{Name: "event-loop"}
+	last := lastHeartbeat.Load()
+	if last == 0 {
+		c.Message = "health monitor has not started"
+		return c
+	}
+	age := time.Since(time.Unix(0, last))
+	if age > heartbeatStaleAfter {
+		c.Message = fmt.Sprintf("last heartbeat %s ago", age.Round(time.Second))
+		return c
+	}
+	c.OK = true
+	return c
+}
+
+func checkClientOpen() HealthCheck {
+	c := HealthCheck{Name: "torrent-client"}
+	if client == nil {
+		c.Message = "client not initialized"
+		return c
+	}
+	select {
+	case <-client.Closed():
+		c.Message = "client closed"
+	default:
+		c.OK = true
+	}
+	return c
+}
+
+func checkListening() HealthCheck {
+	c := HealthCheck{Name: "listeners"}
+	if client == nil {
+		c.Message = "client not initialized"
+		return c
+	}
+	addrs := client.ListenAddrs()
+	if len(addrs) == 0 {
+		c.Message = "no listening sockets"
+		return c
+	}
+	c.OK = true
+	c.Message = fmt.Sprintf("%d listening sockets", len(addrs))
+	return c
+}
+
+func checkDHT() HealthCheck {
+	c := HealthCheck{Name: "dht"}
+	if client == nil {
+		c.Message = "client not initialized"
+		return c
+	}
+	if dhtDisabled {
+		c.OK = true
+		c.Message = "disabled"
+		return c
+	}
+	servers := client.DhtServers()
+	if len(servers) == 0 {
+		c.Message = "no DHT servers"
+		return c
+	}
+	goodNodes := 0
+	for _, s := range servers {
+		if stats, ok := s.Stats().(dht.ServerStats); ok {
+			goodNodes += stats.GoodNodes
+		}
+	}
+	if goodNodes == 0 {
+		c.Message = "DHT not bootstrapped"
+		return c
+	}
+	c.OK = true
+	c.Message = fmt.Sprintf("%d good nodes", goodNodes)
+	return c
+}
+
+func checkDataDirWritable() HealthCheck {
+	c := HealthCheck{Name: "data-dir"}
+	f, err := os.CreateTemp(dataDir, ".readyz-*")
+	if err != nil {
+		c.Message = err.Error()
+		return c
+	}
+	name := f.Name()
+	_, err = f.Write([]byte("ok"))
+	f.Close()
+	os.Remove(name)
+	if err != nil {
+		c.Message = err.Error()
+		return c
+	}
+	c.OK = true
+	c.Message = filepath.Clean(dataDir)
+	return c
+}
+
+// errDiskFreeUnsupported is returned by diskFree on platforms without statfs
+var errDiskFreeUnsupported = errors.New("free space check not supported on this platform")
+
+func checkFreeSpace() HealthCheck {
+	c := HealthCheck{Name: "disk-space"}
+	free, err := diskFree(dataDir)
+	if errors.Is(err, errDiskFreeUnsupported) {
+		c.OK = true
+		c.Message = "unsupported"
+		return c
+	}
+	if err != nil {
+		c.Message = err.Error()
+		return c
+	}
+	min := minFreeDiskBytes()
+	c.Message = fmt.Sprintf("%d MB free", free/1024/1024)
+	if free < min {
+		c.Message += fmt.Sprintf(" (need %d MB)", min/1024/1024)
+		return c
+	}
+	c.OK = true
+	return c
+}
+
+// minFreeDiskBytes reads MIN_FREE_DISK_MB, defaulting to 512MB
+func minFreeDiskBytes() uint64 {
+	mb, err := strconv.ParseUint(os.Getenv("MIN_FREE_DISK_MB"), 10, 64)
+	if err != nil {
+		mb = 512
+	}
+	return mb * 1024 * 1024
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Nebyat19/Torrent-Streamer/logger"
+	"github.com/google/uuid"
+)
+
+// HistoryEntry is one title a user has watched
+type HistoryEntry struct {
+	InfoHash  string    `json:"infoHash"`
+	FilePath  string    `json:"filePath"`
+	Title     string    `json:"title"`
+	Magnet    string    `json:"magnet,omitempty"`
+	Position  float64   `json:"position"`
+	Duration  float64   `json:"duration,omitempty"`
+	Completed bool      `json:"completed"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Thumbnail string    `json:"thumbnail,omitempty"`
+}
+
+const (
+	historyFile          = "data/history.json"
+	historyFlushInterval = 15 * time.Second
+	maxHistoryPerUser    = 100
+
+	// A title counts as watched once playback passes this fraction
+	completedThreshold = 0.9
+)
+
+var historyLog = logger.Component("history")
+
+// historyStore keeps watch history per user in memory and persists it to a
+// JSON file, so it survives both session expiry and restarts.
+type historyStore struct {
+	mu    sync.Mutex
+	path  string
+	users map[string][]*HistoryEntry
+	dirty bool
+}
+
+var history = &historyStore{path: historyFile, users: make(map[string][]*HistoryEntry)}
+
+func (h *historyStore) load() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	data, err := os.ReadFile(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &h.users); err != nil {
+			return err
+		}
+	}
+	// A file holding null leaves the map nil
+	if h.users == nil {
+		h.users = make(map[string][]*HistoryEntry)
+	}
+	return nil
+}
+
+func (h *historyStore) save() error {
+	h.mu.Lock()
+	if !h.dirty {
+		h.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(h.users)
+	h.dirty = false
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+// find returns the user's entry for a file; callers must hold h.mu
+func (h *historyStore) find(userID, infoHash, filePath string) *HistoryEntry {
+	for _, e := range h.users[userID] {
+		if e.InfoHash == infoHash && e.FilePath == filePath {
+			return e
+		}
+	}
+	return nil
+}
+
+// has reports whether the user has watched a file
+func (h *historyStore) has(userID, infoHash, filePath string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.find(userID, infoHash, filePath) != nil
+}
+
+// recordStart notes that a user started a title and returns the stored
+// position to resume from.
+func (h *historyStore) recordStart(userID string, entry HistoryEntry) float64 {
+	if userID == "" {
+		return 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.dirty = true
+	if existing := h.find(userID, entry.InfoHash, entry.FilePath); existing != nil {
+		existing.Title = entry.Title
+		if entry.Magnet != "" {
+			existing.Magnet = entry.Magnet
+		}
+		existing.UpdatedAt = time.Now()
+		if existing.Completed {
+			return 0
+		}
+		return existing.Position
+	}
+
+	entry.UpdatedAt = time.Now()
+	entries := append([]*HistoryEntry{&entry}, h.users[userID]...)
+	if len(entries) > maxHistoryPerUser {
+		entries = entries[:maxHistoryPerUser]
+	}
+	h.users[userID] = entries
+	return 0
+}
+
+func (h *historyStore) updatePosition(userID, infoHash, filePath string, position, duration float64) *HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e := h.find(userID, infoHash, filePath)
+	if e == nil {
+		return nil
+	}
+	e.Position = position
+	if duration > 0 {
+		e.Duration = duration
+	}
+	if e.Duration > 0 && position >= e.Duration*completedThreshold {
+		e.Completed = true
+	}
+	e.UpdatedAt = time.Now()
+	h.dirty = true
+
+	copied := *e
+	return &copied
+}
+
+func (h *historyStore) list(userID string) []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := make([]HistoryEntry, 0, len(h.users[userID]))
+	for _, e := range h.users[userID] {
+		entry := *e
+		if _, err := os.Stat(filepath.Join(thumbnailPath(e.InfoHash, e.FilePath), "poster.jpg")); err == nil {
+			entry.Thumbnail = thumbnailURL(e.InfoHash, e.FilePath, "")
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].UpdatedAt.After(entries[j].UpdatedAt)
+	})
+	return entries
+}
+
+// remove deletes one title, or everything when infoHash is empty
+func (h *historyStore) remove(userID, infoHash string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.dirty = true
+	if infoHash == "" {
+		delete(h.users, userID)
+		return
+	}
+	kept := h.users[userID][:0]
+	for _, e := range h.users[userID] {
+		if e.InfoHash != infoHash {
+			kept = append(kept, e)
+		}
+	}
+	h.users[userID] = kept
+}
+
+func runHistoryFlusher() {
+	defer recoverFromPanic("history-flusher")
+
+	ticker := time.NewTicker(historyFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := history.save(); err != nil {
+				historyLog.Error("Error saving watch history: %v", err)
+			}
+		case <-appContext.Done():
+			if err := history.save(); err != nil {
+				historyLog.Error("Error saving watch history: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// getUserID identifies a browser across sessions. Sessions expire after 30
+// minutes of inactivity; the user cookie lasts a year so history survives.
+func getUserID(w http.ResponseWriter, r *http.Request) string {
+	cookie, err := r.Cookie("ts_user_id")
+	if err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	userID := uuid.New().String()
+	http.SetCookie(w, &http.Cookie{
+		Name:     "ts_user_id",
+		Value:    userID,
+		Path:     "/",
+		HttpOnly: true,
+		MaxAge:   60 * 60 * 24 * 365, // 1 year
+	})
+	return userID
+}
+
+func apiHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, APIResponse{Success: true, Data: history.list(getUserID(w, r))})
+}
+
+func apiHistoryDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	history.remove(getUserID(w, r), r.URL.Query().Get("infoHash"))
+	respondJSON(w, APIResponse{Success: true, Message: "History updated"})
+}
+
+// apiPositionHandler receives periodic playback position reports from the player
+func apiPositionHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		Position float64 `json:"position"`
+		Duration float64 `json:"duration"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		respondError(w, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	if requestData.Position < 0 || requestData.Duration < 0 {
+		respondError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid position")
+		return
+	}
+
+	session := getSession(w, r)
+	if session.Torrent == nil || session.File == nil {
+		respondError(w, http.StatusConflict, codeNoFileSelected, "No file selected")
+		return
+	}
+
+	maybePreloadNext(session, requestData.Position, requestData.Duration)
+
+	userID := getUserID(w, r)
+	entry := history.updatePosition(userID, session.Torrent.InfoHash().HexString(), session.File.Path(),
+		requestData.Position, requestData.Duration)
+	if entry == nil {
+		respondError(w, http.StatusNotFound, codeNotFound, "Title not in history")
+		return
+	}
+
+	respondJSON(w, APIResponse{Success: true, Data: entry})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/Nebyat19/Torrent-Streamer/logger"
+	"github.com/anacrolix/torrent"
+)
+
+var (
+	// Offline mode keeps the client off the internet: no DHT, no trackers
+	// and no port forwarding. Peers come from PEERS, a magnet's x.pe
+	// parameters or a request's peers.
+	offlineMode = envBool("OFFLINE", false)
+
+	// Peers, as host:port, added to every torrent, e.g. a seeding box on
+	// the LAN
+	staticPeers = splitList(os.Getenv("PEERS"))
+)
+
+// validPeerAddrs reports whether every address is a host:port
+func validPeerAddrs(addrs []string) bool {
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || host == "" {
+			return false
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return false
+		}
+	}
+	return true
+}
+
+// withPeers adds PEERS to a torrent spec. In offline mode it also drops the
+// spec's trackers, which can't be reached.
+func withPeers(spec *torrent.TorrentSpec) {
+	spec.PeerAddrs = append(spec.PeerAddrs, staticPeers...)
+	if offlineMode {
+		spec.Trackers = nil
+	}
+}
+
+func peerInfos(addrs []string) []torrent.PeerInfo {
+	var peers []torrent.PeerInfo
+	for _, addr := range addrs {
+		peers = append(peers, torrent.PeerInfo{
+			Addr:    torrent.StringAddr(addr),
+			Source:  torrent.PeerSourceDirect,
+			Trusted: true,
+		})
+	}
+	return peers
+}
+
+// apiAddPeersHandler connects a running torrent to explicit peers
+func apiAddPeersHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		InfoHash string   `json:"infoHash"`
+		Peers    []string `json:"peers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		respondError(w, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON")
+		return
+	}
+	if len(requestData.Peers) == 0 || !validPeerAddrs(requestData.Peers) {
+		respondError(w, http.StatusBadRequest, codeInvalidRequest, "peers must be host:port addresses")
+		return
+	}
+	t, ok := requestTorrent(w, r, requestData.InfoHash)
+	if !ok {
+		return
+	}
+
+	n := t.AddPeers(peerInfos(requestData.Peers))
+	logger.FromContext(r.Context()).Info("Added %d peers to %s", n, t.Name(), logger.InfoHash(t.InfoHash().HexString()))
+	respondJSON(w, APIResponse{Success: true, Message: fmt.Sprintf("Added %d peers", n)})
+}
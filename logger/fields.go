@@ -0,0 +1,143 @@
+package logger
+
+import "context"
+
+// Field is a key/value pair attached to a log entry
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F builds a Field
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Well-known field keys, kept consistent so log pipelines can filter on them
+const (
+	SessionIDKey = "session_id"
+	InfoHashKey  = "info_hash"
+	RequestIDKey = "request_id"
+)
+
+func SessionID(id string) Field {
+	return Field{Key: SessionIDKey, Value: id}
+}
+
+func InfoHash(hash string) Field {
+	return Field{Key: InfoHashKey, Value: hash}
+}
+
+func RequestID(id string) Field {
+	return Field{Key: RequestIDKey, Value: id}
+}
+
+// splitFields pulls Field values out of printf args and appends them to fields
+func splitFields(args []interface{}, fields []Field) ([]interface{}, []Field) {
+	hasFields := false
+	for _, a := range args {
+		if _, ok := a.(Field); ok {
+			hasFields = true
+			break
+		}
+	}
+	if !hasFields {
+		return args, fields
+	}
+
+	plain := make([]interface{}, 0, len(args))
+	merged := append([]Field(nil), fields...)
+	for _, a := range args {
+		if f, ok := a.(Field); ok {
+			merged = append(merged, f)
+		} else {
+			plain = append(plain, a)
+		}
+	}
+	return plain, merged
+}
+
+// Entry is a logger bound to a set of fields, such as a session or torrent
+type Entry struct {
+	logger    *Logger
+	component string
+	fields    []Field
+}
+
+// With returns an Entry that adds fields to every message
+func (l *Logger) With(fields ...Field) *Entry {
+	return &Entry{logger: l, fields: fields}
+}
+
+// With returns an Entry on the default logger
+func With(fields ...Field) *Entry {
+	return GetLogger().With(fields...)
+}
+
+// With returns a copy of the entry with additional fields; a field whose key
+// is already present replaces the earlier value.
+func (e *Entry) With(fields ...Field) *Entry {
+	merged := make([]Field, 0, len(e.fields)+len(fields))
+	for _, f := range e.fields {
+		replaced := false
+		for _, nf := range fields {
+			if nf.Key == f.Key {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, f)
+		}
+	}
+	merged = append(merged, fields...)
+	return &Entry{logger: e.logger, component: e.component, fields: merged}
+}
+
+func (e *Entry) Debug(format string, args ...interface{}) {
+	e.logger.log(DEBUG, 2, e.component, e.fields, format, args...)
+}
+
+func (e *Entry) Info(format string, args ...interface{}) {
+	e.logger.log(INFO, 2, e.component, e.fields, format, args...)
+}
+
+func (e *Entry) Warn(format string, args ...interface{}) {
+	e.logger.log(WARN, 2, e.component, e.fields, format, args...)
+}
+
+func (e *Entry) Error(format string, args ...interface{}) {
+	e.logger.log(ERROR, 2, e.component, e.fields, format, args...)
+}
+
+func (e *Entry) Fatal(format string, args ...interface{}) {
+	e.logger.log(FATAL, 2, e.component, e.fields, format, args...)
+}
+
+// Logf logs at a level chosen at runtime, e.g. when bridging another logger
+func (e *Entry) Logf(level LogLevel, format string, args ...interface{}) {
+	e.logger.log(level, 2, e.component, e.fields, format, args...)
+}
+
+// Enabled reports whether a message at level would be written
+func (e *Entry) Enabled(level LogLevel) bool {
+	return e.logger.Enabled(e.component, level)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the given Entry
+func NewContext(ctx context.Context, e *Entry) context.Context {
+	return context.WithValue(ctx, contextKey{}, e)
+}
+
+// FromContext returns the Entry stored in ctx, or a bare Entry on the
+// default logger when there is none.
+func FromContext(ctx context.Context) *Entry {
+	if ctx != nil {
+		if e, ok := ctx.Value(contextKey{}).(*Entry); ok {
+			return e
+		}
+	}
+	return GetLogger().With()
+}
@@ -0,0 +1,201 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ComponentKey is the field that names the component an Entry belongs to
+const ComponentKey = "component"
+
+type levelSnapshot struct {
+	level      LogLevel
+	components map[string]LogLevel
+}
+
+func (l LogLevel) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// ParseLevel accepts level names case-insensitively, plus "warning"
+func ParseLevel(s string) (LogLevel, error) {
+	name := strings.ToUpper(strings.TrimSpace(s))
+	if name == "WARNING" {
+		return WARN, nil
+	}
+	for level, levelName := range levelNames {
+		if levelName == name {
+			return level, nil
+		}
+	}
+	return INFO, fmt.Errorf("unknown log level %q", s)
+}
+
+// Component returns an Entry whose level can be tuned independently with
+// SetComponentLevel, e.g. Component("http").
+func (l *Logger) Component(name string) *Entry {
+	return &Entry{logger: l, component: name, fields: []Field{F(ComponentKey, name)}}
+}
+
+// Component returns a component Entry on the default logger
+func Component(name string) *Entry {
+	return GetLogger().Component(name)
+}
+
+// Enabled reports whether a message for component at level would be written
+func (l *Logger) Enabled(component string, level LogLevel) bool {
+	l.levelMu.RLock()
+	defer l.levelMu.RUnlock()
+
+	min := l.level
+	if component != "" {
+		if cl, ok := l.componentLevels[component]; ok {
+			min = cl
+		}
+	}
+	return level >= min
+}
+
+// SetLevel changes the default level used by components without their own
+func (l *Logger) SetLevel(level LogLevel) {
+	l.levelMu.Lock()
+	defer l.levelMu.Unlock()
+	l.level = level
+}
+
+// SetComponentLevel overrides the level for a single component
+func (l *Logger) SetComponentLevel(component string, level LogLevel) {
+	l.levelMu.Lock()
+	defer l.levelMu.Unlock()
+
+	if l.componentLevels == nil {
+		l.componentLevels = make(map[string]LogLevel)
+	}
+	l.componentLevels[component] = level
+}
+
+// ClearComponentLevel makes a component follow the default level again
+func (l *Logger) ClearComponentLevel(component string) {
+	l.levelMu.Lock()
+	defer l.levelMu.Unlock()
+	delete(l.componentLevels, component)
+}
+
+// Levels returns the default level under "default" and every component override
+func (l *Logger) Levels() map[string]string {
+	l.levelMu.RLock()
+	defer l.levelMu.RUnlock()
+
+	levels := map[string]string{"default": l.level.String()}
+	for component, level := range l.componentLevels {
+		levels[component] = level.String()
+	}
+	return levels
+}
+
+// LevelSpec renders the current levels in the format ApplyLevelSpec accepts
+func (l *Logger) LevelSpec() string {
+	l.levelMu.RLock()
+	defer l.levelMu.RUnlock()
+
+	parts := []string{strings.ToLower(l.level.String())}
+	components := make([]string, 0, len(l.componentLevels))
+	for component := range l.componentLevels {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	for _, component := range components {
+		parts = append(parts, component+"="+strings.ToLower(l.componentLevels[component].String()))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ApplyLevelSpec parses a spec such as "info,http=debug,torrent=warn". A bare
+// level sets the default; component=level pairs set overrides. Nothing is
+// changed if any part of the spec is invalid.
+func (l *Logger) ApplyLevelSpec(spec string) error {
+	var defaultLevel *LogLevel
+	overrides := make(map[string]LogLevel)
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		component, levelName, found := strings.Cut(part, "=")
+		if !found {
+			level, err := ParseLevel(part)
+			if err != nil {
+				return err
+			}
+			defaultLevel = &level
+			continue
+		}
+		component = strings.TrimSpace(component)
+		if component == "" {
+			return fmt.Errorf("missing component in %q", part)
+		}
+		level, err := ParseLevel(levelName)
+		if err != nil {
+			return err
+		}
+		overrides[component] = level
+	}
+
+	l.levelMu.Lock()
+	defer l.levelMu.Unlock()
+
+	if defaultLevel != nil {
+		l.level = *defaultLevel
+	}
+	if l.componentLevels == nil {
+		l.componentLevels = make(map[string]LogLevel)
+	}
+	for component, level := range overrides {
+		l.componentLevels[component] = level
+	}
+	return nil
+}
+
+// ToggleDebug switches every component to DEBUG, or restores the levels
+// that were in effect before the previous toggle. It reports whether debug
+// is now on.
+func (l *Logger) ToggleDebug() bool {
+	l.levelMu.Lock()
+	defer l.levelMu.Unlock()
+
+	if l.savedLevels != nil {
+		l.level = l.savedLevels.level
+		l.componentLevels = l.savedLevels.components
+		l.savedLevels = nil
+		return false
+	}
+
+	saved := &levelSnapshot{level: l.level, components: make(map[string]LogLevel)}
+	debug := make(map[string]LogLevel)
+	for component, level := range l.componentLevels {
+		saved.components[component] = level
+		debug[component] = DEBUG
+	}
+	l.savedLevels = saved
+	l.level = DEBUG
+	l.componentLevels = debug
+	return true
+}
+
+// Global level helpers
+func SetLevel(level LogLevel) {
+	GetLogger().SetLevel(level)
+}
+
+func SetComponentLevel(component string, level LogLevel) {
+	GetLogger().SetComponentLevel(component, level)
+}
+
+func ApplyLevelSpec(spec string) error {
+	return GetLogger().ApplyLevelSpec(spec)
+}
@@ -1,12 +1,16 @@
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -29,14 +33,52 @@ var levelNames = map[LogLevel]string{
 	FATAL: "FATAL",
 }
 
+// Format selects how log entries are rendered
+type Format int
+
+const (
+	TextFormat Format = iota
+	JSONFormat
+)
+
+// ParseFormat maps "json" to JSONFormat and anything else to TextFormat
+func ParseFormat(s string) Format {
+	if strings.EqualFold(strings.TrimSpace(s), "json") {
+		return JSONFormat
+	}
+	return TextFormat
+}
+
 type Logger struct {
-	file       *os.File
-	logger     *log.Logger
-	level      LogLevel
-	maxSize    int64
-	maxBackups int
-	mu         sync.Mutex
-	logPath    string
+	file        *os.File
+	logger      *log.Logger
+	format      Format
+	maxSize     int64
+	maxBackups  int
+	maxAge      time.Duration
+	rotateEvery time.Duration
+	compress    bool
+	size        int64
+	openedAt    time.Time
+	rotateAfter time.Time // Set after a failed rotation to back off
+	mu          sync.Mutex
+	logPath     string
+	console     io.Writer // Gets a copy of every entry; stdout when nil
+
+	// Levels are read on every call, so they have their own lock
+	levelMu         sync.RWMutex
+	level           LogLevel
+	componentLevels map[string]LogLevel
+	savedLevels     *levelSnapshot
+}
+
+// Options controls file output and rotation
+type Options struct {
+	MaxSizeMB   int           // Rotate once the file reaches this size
+	MaxBackups  int           // Rotated files to keep, 0 keeps all
+	MaxAge      time.Duration // Delete rotated files older than this, 0 keeps all
+	RotateEvery time.Duration // Rotate files older than this, 0 disables
+	Compress    bool          // Gzip rotated files
 }
 
 var (
@@ -46,12 +88,32 @@ var (
 
 // Initialize creates and returns a singleton logger instance
 func Initialize(logPath string, level LogLevel, maxSizeMB int, maxBackups int) *Logger {
+	return InitializeWithOptions(logPath, level, Options{
+		MaxSizeMB:   maxSizeMB,
+		MaxBackups:  maxBackups,
+		MaxAge:      7 * 24 * time.Hour,
+		RotateEvery: 24 * time.Hour,
+		Compress:    true,
+	})
+}
+
+// InitializeWithOptions is Initialize with full control over rotation
+func InitializeWithOptions(logPath string, level LogLevel, opts Options) *Logger {
 	once.Do(func() {
 		defaultLogger = &Logger{
-			level:      level,
-			maxSize:    int64(maxSizeMB) * 1024 * 1024, // Convert MB to bytes
-			maxBackups: maxBackups,
-			logPath:    logPath,
+			level:       level,
+			format:      ParseFormat(os.Getenv("LOG_FORMAT")),
+			maxSize:     int64(opts.MaxSizeMB) * 1024 * 1024, // Convert MB to bytes
+			maxBackups:  opts.MaxBackups,
+			maxAge:      opts.MaxAge,
+			rotateEvery: opts.RotateEvery,
+			compress:    opts.Compress,
+			logPath:     logPath,
+		}
+		if spec := os.Getenv("LOG_LEVEL"); spec != "" {
+			if err := defaultLogger.ApplyLevelSpec(spec); err != nil {
+				log.Printf("Ignoring invalid LOG_LEVEL: %v", err)
+			}
 		}
 		defaultLogger.setupLogger()
 	})
@@ -61,11 +123,22 @@ func Initialize(logPath string, level LogLevel, maxSizeMB int, maxBackups int) *
 // GetLogger returns the singleton logger instance
 func GetLogger() *Logger {
 	if defaultLogger == nil {
-		return Initialize("logs/app.log", INFO, 10, 5)
+		logPath := os.Getenv("LOG_FILE")
+		if logPath == "" {
+			logPath = "logs/app.log"
+		}
+		return Initialize(logPath, INFO, 10, 5)
 	}
 	return defaultLogger
 }
 
+// SetFormat switches between text and JSON lines output
+func (l *Logger) SetFormat(format Format) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.format = format
+}
+
 func (l *Logger) setupLogger() {
 	// Create logs directory if it doesn't exist
 	logDir := filepath.Dir(l.logPath)
@@ -82,52 +155,37 @@ func (l *Logger) setupLogger() {
 	}
 
 	l.file = file
+	l.size = 0
+	if info, err := file.Stat(); err == nil {
+		l.size = info.Size()
+	}
+	l.openedAt = time.Now()
 
 	// Create multi-writer to write to both file and stdout
-	multiWriter := io.MultiWriter(os.Stdout, file)
+	multiWriter := io.MultiWriter(l.consoleWriter(), file)
 	l.logger = log.New(multiWriter, "", 0)
 }
 
-func (l *Logger) rotateLogIfNeeded() {
-	if l.file == nil {
-		return
-	}
-
-	info, err := l.file.Stat()
-	if err != nil {
-		return
-	}
-
-	if info.Size() >= l.maxSize {
-		l.rotateLog()
+func (l *Logger) consoleWriter() io.Writer {
+	if l.console == nil {
+		return os.Stdout
 	}
+	return l.console
 }
 
-func (l *Logger) rotateLog() {
+// SetConsole sends the console copy of log entries to w instead of stdout,
+// for commands whose stdout is read by scripts
+func (l *Logger) SetConsole(w io.Writer) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-
-	// Close current file
+	l.console = w
 	if l.file != nil {
-		l.file.Close()
+		l.logger = log.New(io.MultiWriter(w, l.file), "", 0)
 	}
-
-	// Rotate existing log files
-	for i := l.maxBackups - 1; i >= 1; i-- {
-		oldPath := fmt.Sprintf("%s.%d", l.logPath, i)
-		newPath := fmt.Sprintf("%s.%d", l.logPath, i+1)
-		os.Rename(oldPath, newPath)
-	}
-
-	// Move current log to .1
-	os.Rename(l.logPath, l.logPath+".1")
-
-	// Create new log file
-	l.setupLogger()
 }
 
-func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
-	if level < l.level {
+func (l *Logger) log(level LogLevel, depth int, component string, fields []Field, format string, args ...interface{}) {
+	if !l.Enabled(component, level) {
 		return
 	}
 
@@ -138,7 +196,7 @@ func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
 	l.rotateLogIfNeeded()
 
 	// Get caller information
-	_, file, line, ok := runtime.Caller(2)
+	_, file, line, ok := runtime.Caller(depth)
 	if ok {
 		file = filepath.Base(file)
 	} else {
@@ -146,60 +204,128 @@ func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
 		line = 0
 	}
 
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
+	args, fields = splitFields(args, fields)
+	now := time.Now()
 	levelName := levelNames[level]
-
 	message := fmt.Sprintf(format, args...)
-	logEntry := fmt.Sprintf("[%s] [%s] [%s:%d] %s", timestamp, levelName, file, line, message)
-    fmt.Println(logEntry)
-	// if l.logger != nil {
-	// 	l.logger.Println(logEntry)
-	// } else {
-	// 	// Fallback to standard log if logger is not initialized
-	// 	log.Println(logEntry)
-	// }
+
+	var logEntry string
+	if l.format == JSONFormat {
+		logEntry = formatJSON(now, levelName, file, line, message, fields)
+	} else {
+		logEntry = formatText(now, levelName, file, line, message, fields)
+	}
+	if l.logger != nil {
+		l.logger.Println(logEntry)
+		l.size += int64(len(logEntry)) + 1
+	} else {
+		// The log file could not be opened, so only the console copy is
+		// left. It goes to stderr unless SetConsole picked a writer, since
+		// stdout may be read by scripts.
+		console := l.console
+		if console == nil {
+			console = os.Stderr
+		}
+		fmt.Fprintln(console, logEntry)
+	}
+}
+
+func formatText(now time.Time, levelName, file string, line int, message string, fields []Field) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] [%s] [%s:%d] %s", now.Format("2006-01-02 15:04:05"), levelName, file, line, message)
+	for _, f := range fields {
+		b.WriteString(" ")
+		b.WriteString(f.Key)
+		b.WriteString("=")
+		value := fmt.Sprint(fieldValue(f.Value))
+		if value == "" || strings.ContainsAny(value, " \t\"=") {
+			value = strconv.Quote(value)
+		}
+		b.WriteString(value)
+	}
+	return b.String()
 }
 
-// Logging methods
+func formatJSON(now time.Time, levelName, file string, line int, message string, fields []Field) string {
+	var b bytes.Buffer
+	b.WriteString("{")
+	writeJSONField(&b, "time", now.Format(time.RFC3339Nano), true)
+	writeJSONField(&b, "level", levelName, false)
+	writeJSONField(&b, "caller", file+":"+strconv.Itoa(line), false)
+	writeJSONField(&b, "msg", message, false)
+	for _, f := range fields {
+		writeJSONField(&b, f.Key, fieldValue(f.Value), false)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func writeJSONField(b *bytes.Buffer, key string, value interface{}, first bool) {
+	if !first {
+		b.WriteString(",")
+	}
+	k, _ := json.Marshal(key)
+	b.Write(k)
+	b.WriteString(":")
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(v)
+}
+
+// fieldValue renders errors and Stringers as text so they survive JSON encoding
+func fieldValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case error:
+		return t.Error()
+	case fmt.Stringer:
+		return t.String()
+	}
+	return v
+}
+
+// Logging methods. Any Field values passed in args are attached to the
+// entry as key/value pairs instead of being formatted into the message.
 func (l *Logger) Debug(format string, args ...interface{}) {
-	l.log(DEBUG, format, args...)
+	l.log(DEBUG, 2, "", nil, format, args...)
 }
 
 func (l *Logger) Info(format string, args ...interface{}) {
-	l.log(INFO, format, args...)
+	l.log(INFO, 2, "", nil, format, args...)
 }
 
 func (l *Logger) Warn(format string, args ...interface{}) {
-	l.log(WARN, format, args...)
+	l.log(WARN, 2, "", nil, format, args...)
 }
 
 func (l *Logger) Error(format string, args ...interface{}) {
-	l.log(ERROR, format, args...)
+	l.log(ERROR, 2, "", nil, format, args...)
 }
 
 func (l *Logger) Fatal(format string, args ...interface{}) {
-	l.log(FATAL, format, args...)
+	l.log(FATAL, 2, "", nil, format, args...)
 }
 
 // Global logging functions
 func Debug(format string, args ...interface{}) {
-	GetLogger().Debug(format, args...)
+	GetLogger().log(DEBUG, 2, "", nil, format, args...)
 }
 
 func Info(format string, args ...interface{}) {
-	GetLogger().Info(format, args...)
+	GetLogger().log(INFO, 2, "", nil, format, args...)
 }
 
 func Warn(format string, args ...interface{}) {
-	GetLogger().Warn(format, args...)
+	GetLogger().log(WARN, 2, "", nil, format, args...)
 }
 
 func Error(format string, args ...interface{}) {
-	GetLogger().Error(format, args...)
+	GetLogger().log(ERROR, 2, "", nil, format, args...)
 }
 
 func Fatal(format string, args ...interface{}) {
-	GetLogger().Fatal(format, args...)
+	GetLogger().log(FATAL, 2, "", nil, format, args...)
 }
 
 // Close closes the log file
@@ -207,7 +333,5 @@ func (l *Logger) Close() {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if l.file != nil {
-		l.file.Close()
-	}
+	l.closeFile()
 }
@@ -0,0 +1,167 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// pruneMu serializes compression and cleanup of rotated files, which run
+// in the background after each rotation.
+var pruneMu sync.Mutex
+
+// rotateRetryDelay is how long to wait before trying again after a
+// rotation failed, rather than failing on every entry
+const rotateRetryDelay = time.Minute
+
+// rotateLogIfNeeded rotates the file once it is too large or too old.
+// Callers must hold l.mu.
+func (l *Logger) rotateLogIfNeeded() {
+	if l.file == nil || time.Now().Before(l.rotateAfter) {
+		return
+	}
+
+	tooBig := l.maxSize > 0 && l.size >= l.maxSize
+	tooOld := l.rotateEvery > 0 && time.Since(l.openedAt) >= l.rotateEvery
+	if tooBig || tooOld {
+		l.rotateLog()
+	}
+}
+
+// rotateLog moves the current file aside and opens a fresh one. Callers
+// must hold l.mu.
+func (l *Logger) rotateLog() {
+	l.closeFile()
+
+	backup := l.backupPath(time.Now())
+	if err := os.Rename(l.logPath, backup); err != nil {
+		// The file is reopened at full size, so don't try again right away
+		log.Printf("Failed to rotate log file, retrying in %v: %v", rotateRetryDelay, err)
+		l.rotateAfter = time.Now().Add(rotateRetryDelay)
+		l.setupLogger()
+		return
+	}
+
+	l.setupLogger()
+
+	go l.finishRotation(backup)
+}
+
+// backupPath turns logs/app.log into logs/app-20060102T150405.000.log
+func (l *Logger) backupPath(t time.Time) string {
+	ext := filepath.Ext(l.logPath)
+	base := strings.TrimSuffix(l.logPath, ext)
+	return fmt.Sprintf("%s-%s%s", base, t.Format("20060102T150405.000"), ext)
+}
+
+func (l *Logger) finishRotation(backup string) {
+	pruneMu.Lock()
+	defer pruneMu.Unlock()
+
+	if l.compress {
+		if err := compressFile(backup); err != nil {
+			log.Printf("Failed to compress rotated log %s: %v", backup, err)
+		}
+	}
+	l.pruneBackups()
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	src.Close()
+	return os.Remove(path)
+}
+
+// pruneBackups enforces maxBackups and maxAge on rotated files
+func (l *Logger) pruneBackups() {
+	ext := filepath.Ext(l.logPath)
+	base := strings.TrimSuffix(l.logPath, ext)
+	matches, err := filepath.Glob(base + "-*" + ext + "*")
+	if err != nil {
+		return
+	}
+
+	// Timestamps in the names sort chronologically; newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+
+	now := time.Now()
+	for i, path := range matches {
+		expired := false
+		if l.maxAge > 0 {
+			if info, err := os.Stat(path); err == nil && now.Sub(info.ModTime()) > l.maxAge {
+				expired = true
+			}
+		}
+		if expired || (l.maxBackups > 0 && i >= l.maxBackups) {
+			os.Remove(path)
+		}
+	}
+}
+
+func (l *Logger) closeFile() {
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file = nil
+	l.logger = nil
+}
+
+// Reopen closes and reopens the log file at its configured path, for use
+// after an external tool such as logrotate has moved it away.
+func (l *Logger) Reopen() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closeFile()
+	l.setupLogger()
+}
+
+// WatchSIGHUP reopens the default logger's file whenever the process
+// receives SIGHUP.
+func WatchSIGHUP() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	go func() {
+		for range ch {
+			GetLogger().Reopen()
+			Info("Log file reopened after SIGHUP")
+		}
+	}()
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package logger
+
+// WatchLevelSignal is a no-op on platforms without SIGUSR1
+func WatchLevelSignal() {}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package logger
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WatchLevelSignal toggles debug logging for every component whenever the
+// process receives SIGUSR1.
+func WatchLevelSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+
+	go func() {
+		for range ch {
+			l := GetLogger()
+			if l.ToggleDebug() {
+				Warn("Debug logging enabled by SIGUSR1")
+			} else {
+				Warn("Debug logging disabled by SIGUSR1, levels restored to %s", l.LevelSpec())
+			}
+		}
+	}()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/Nebyat19/Torrent-Streamer/logger"
+	alog "github.com/anacrolix/log"
+)
+
+// Component loggers; levels for each can be changed at runtime through
+// LOG_LEVEL, /api/admin/log-level or SIGUSR1.
+var (
+	httpLog      = logger.Component("http")
+	torrentLog   = logger.Component("torrent")
+	subtitleLog  = logger.Component("subtitles")
+	sessionLog   = logger.Component("sessions")
+	anacrolixLog = logger.Component("anacrolix")
+)
+
+// anacrolixHandler routes the torrent library's own log records into our
+// logger under the "anacrolix" component.
+type anacrolixHandler struct{}
+
+func (anacrolixHandler) Handle(r alog.Record) {
+	level := mapAnacrolixLevel(r.Level)
+	if !anacrolixLog.Enabled(level) {
+		return
+	}
+	anacrolixLog.Logf(level, "%s", r.Text(), logger.F("source", strings.Join(r.Names, " ")))
+}
+
+func mapAnacrolixLevel(level alog.Level) logger.LogLevel {
+	switch {
+	case level.LessThan(alog.Info):
+		return logger.DEBUG
+	case level.LessThan(alog.Warning):
+		return logger.INFO
+	case level.LessThan(alog.Error):
+		return logger.WARN
+	default:
+		return logger.ERROR
+	}
+}
+
+// newAnacrolixLogger builds the logger handed to the torrent client. The
+// library's own filter lets everything through and anacrolixHandler checks
+// the component level on each record, so level changes at runtime apply
+// without restarting the client.
+func newAnacrolixLogger() alog.Logger {
+	l := alog.NewLogger("torrent").WithFilterLevel(alog.Debug)
+	l.SetHandlers(anacrolixHandler{})
+	return l
+}
+
+func apiAdminLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, APIResponse{Success: true, Data: logger.GetLogger().Levels()})
+}
+
+func apiAdminSetLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	l := logger.GetLogger()
+
+	var requestData struct {
+		Spec      string `json:"spec"`
+		Component string `json:"component"`
+		Level     string `json:"level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		respondError(w, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	spec := requestData.Spec
+	if spec == "" && requestData.Level != "" {
+		spec = requestData.Level
+		if requestData.Component != "" {
+			spec = requestData.Component + "=" + requestData.Level
+		}
+	}
+	if spec == "" {
+		respondError(w, http.StatusBadRequest, codeInvalidRequest, "spec or level is required")
+		return
+	}
+	if err := l.ApplyLevelSpec(spec); err != nil {
+		respondError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	logger.Warn("Log levels changed via admin API: %s", l.LevelSpec())
+
+	respondJSON(w, APIResponse{Success: true, Data: l.Levels()})
+}
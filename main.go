@@ -6,41 +6,61 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Nebyat19/Torrent-Streamer/logger"
+	"github.com/Nebyat19/Torrent-Streamer/metrics"
+	"github.com/Nebyat19/Torrent-Streamer/probe"
+	"github.com/Nebyat19/Torrent-Streamer/release"
 	"github.com/anacrolix/torrent"
-	"github.com/asticode/go-astisub"
+	"github.com/anacrolix/torrent/metainfo"
 	"github.com/google/uuid"
 )
 
 type UserSession struct {
-	Torrent      *torrent.Torrent
-	File         *torrent.File
-	Subtitles    []Subtitle
-	LastActivity time.Time
-	StatusMsg    string
+	Torrent        *torrent.Torrent
+	File           *torrent.File
+	Subtitles      []Subtitle
+	LastActivity   time.Time
+	StatusMsg      string
+	UserID         string
+	ResumePosition float64
+	Magnet         string
+	Playlist       []*torrent.File
+	PlaylistIndex  int
+	PreloadedIndex int
+	Media          *probe.Info
+	MediaError     string
+	MetadataFetch  *metadataFetch
 }
 
 type Subtitle struct {
-	Name string `json:"name"`
-	Path string `json:"path"`
-	Lang string `json:"lang"`
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Lang  string `json:"lang"`
+	Group string `json:"group,omitempty"`
 }
 
 type APIResponse struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message,omitempty"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Success   bool        `json:"success"`
+	Message   string      `json:"message,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Code      string      `json:"code,omitempty"`
+	RequestID string      `json:"requestId,omitempty"`
 }
 
 type StreamStatus struct {
@@ -52,31 +72,89 @@ type StreamStatus struct {
 	FileSize    int64      `json:"fileSize"`
 	FileType    string     `json:"fileType"`
 	Subtitles   []Subtitle `json:"subtitles"`
+
+	ResumePosition float64 `json:"resumePosition,omitempty"`
+	PlaylistIndex  int     `json:"playlistIndex"`
+	PlaylistLength int     `json:"playlistLength"`
+
+	Title   string        `json:"title,omitempty"`
+	Release *release.Info `json:"release,omitempty"`
+
+	Media      *probe.Info `json:"media,omitempty"`
+	MediaError string      `json:"mediaError,omitempty"`
+	Warnings   []string    `json:"warnings,omitempty"`
+
+	AudioTracks []AudioTrack `json:"audioTracks,omitempty"`
+	Profiles    []string     `json:"profiles,omitempty"`
+
+	Poster         string `json:"poster,omitempty"`
+	ThumbnailTrack string `json:"thumbnailTrack,omitempty"`
+
+	Peers *PeerStats `json:"peers,omitempty"`
+
+	// Set until the torrent's metadata arrives
+	Metadata *MetadataProgress `json:"metadata,omitempty"`
+}
+
+// PeerStats counts the swarm of the session's torrent
+type PeerStats struct {
+	Active  int `json:"active"`
+	Seeders int `json:"seeders"`
+	Known   int `json:"known"`
 }
 
 var (
 	client      *torrent.Client
+	dataDir     string
 	sessions    = make(map[string]*UserSession)
 	sessionLock sync.Mutex
 	appContext  context.Context
 	appCancel   context.CancelFunc
 )
 
+// Large season packs have torrent files of a few megabytes
+const maxTorrentFileSize = 10 << 20
+
 func main() {
 	// Initialize application context
 	appContext, appCancel = context.WithCancel(context.Background())
 	defer appCancel()
 
+	// "play <magnet>" streams a single file without the web server
+	if len(os.Args) > 1 && os.Args[1] == "play" {
+		code := runPlay(os.Args[2:])
+		appCancel()
+		os.Exit(code)
+	}
+
+	logger.WatchSIGHUP()
+	logger.WatchLevelSignal()
+	go watchShutdownSignals()
 	logger.Info("=== Torrent Streamer API Starting ===")
 
 	// Start health monitoring
 	go startHealthMonitor()
 
+	// Load watch history and persist it in the background
+	if err := history.load(); err != nil {
+		logger.Warn("Could not load watch history: %v", err)
+	}
+	go runHistoryFlusher()
+	go runQueueScheduler()
+
+	if err := downloads.load(); err != nil {
+		logger.Warn("Could not load downloads: %v", err)
+	}
+	if err := defaultTrackers.load(); err != nil {
+		logger.Warn("Could not load tracker list: %v", err)
+	}
+
 	// Run main application with recovery
 	restartCount := 0
 	maxRestarts := 5
+	exited := false
 
-	for restartCount <= maxRestarts {
+	for !exited && restartCount <= maxRestarts {
 		func() {
 			defer func() {
 				if r := recover(); r != nil {
@@ -97,11 +175,11 @@ func main() {
 			}
 
 			// If we get here, the application exited normally
-			restartCount = maxRestarts + 1 // Exit the loop
+			exited = true
 		}()
 	}
 
-	if restartCount > maxRestarts {
+	if !exited {
 		logger.Error("Maximum restart attempts (%d) exceeded. Application will exit.", maxRestarts)
 		os.Exit(1)
 	}
@@ -111,7 +189,10 @@ func main() {
 
 func runApplication() error {
 	defer func() {
+		downloads.stop()
+		queue.reset()
 		if client != nil {
+			saveDHTNodes()
 			client.Close()
 			logger.Warn("Torrent client closed")
 		}
@@ -129,15 +210,13 @@ func runApplication() error {
 	if err := createDirectories(); err != nil {
 		return fmt.Errorf("failed to create directories: %v", err)
 	}
+
+	// Pick up background downloads where they left off
+	downloads.resume()
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
-	// Add readiness probe
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
-
 	// Start HTTP server
 	server := &http.Server{
 		Addr:         ":"+port,
@@ -183,7 +262,8 @@ func runApplication() error {
 
 func initializeTorrentClient() error {
     cfg := torrent.NewDefaultClientConfig()
-    cfg.DataDir = os.TempDir()
+    dataDir = os.TempDir()
+    cfg.DataDir = dataDir
     
     // ===== NEW STREAMING OPTIMIZATIONS =====
  
@@ -192,18 +272,23 @@ func initializeTorrentClient() error {
     cfg.MaxAllocPeerRequestDataPerConn = 1 << 20                 // ~1MB buffer limit
     // ======================================
 
+    cfg.Logger = newAnacrolixLogger()
+    configureDHT(cfg)
+    cfg.NoDefaultPortForwarding = offlineMode
+
     var err error
     client, err = torrent.NewClient(cfg)
     if err != nil {
-        logger.Error("Failed to create torrent client: %v", err)
+        torrentLog.Error("Failed to create torrent client: %v", err)
         return err
     }
-    logger.Info("Torrent client initialized (streaming-optimized)")
+    loadDHTNodes()
+    torrentLog.Info("Torrent client initialized (streaming-optimized)")
     return nil
 }
 
 func createDirectories() error {
-	dirs := []string{"logs", "subtitles", "static"}
+	dirs := []string{"logs", "subtitles", "static", "data", thumbnailDir, libraryDir}
 
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -217,20 +302,56 @@ func createDirectories() error {
 }
 
 func setupRoutes() {
-	// API routes
-	http.HandleFunc("/api/status", corsHandler(safeHTTPHandler("api-status", apiStatusHandler)))
-	http.HandleFunc("/api/stream", corsHandler(safeHTTPHandler("api-stream", apiStreamHandler)))
-	http.HandleFunc("/api/progress", corsHandler(safeHTTPHandler("api-progress", apiProgressHandler)))
-	http.HandleFunc("/api/upload-subtitle", corsHandler(safeHTTPHandler("api-upload-subtitle", apiUploadSubtitleHandler)))
-
-	// Add this line in setupRoutes() after the existing API routes
-	http.HandleFunc("/api/reset-session", corsHandler(safeHTTPHandler("api-reset-session", apiResetSessionHandler)))
+	// API routes, served under /api/v1 and the deprecated /api aliases
+	apiRoute("/status", "api-status", methods{"GET": apiStatusHandler}.ServeHTTP)
+	apiRoute("/stream", "api-stream", methods{"POST": apiStreamHandler, "DELETE": apiStreamStopHandler}.ServeHTTP)
+	apiRoute("/progress", "api-progress", methods{"GET": apiProgressHandler}.ServeHTTP)
+	apiRoute("/upload-subtitle", "api-upload-subtitle", methods{"POST": apiUploadSubtitleHandler}.ServeHTTP)
+	apiRoute("/pieces", "api-pieces", methods{"GET": apiPiecesHandler}.ServeHTTP)
+	apiRoute("/pieces/events", "api-pieces-events", methods{"GET": apiPiecesEventsHandler}.ServeHTTP)
+	apiRoute("/history", "api-history", methods{"GET": apiHistoryHandler, "DELETE": apiHistoryDeleteHandler}.ServeHTTP)
+	apiRoute("/position", "api-position", methods{"POST": apiPositionHandler}.ServeHTTP)
+	apiRoute("/playlist", "api-playlist", methods{"GET": apiPlaylistHandler, "POST": apiPlaylistActionHandler}.ServeHTTP)
+	apiRoute("/thumbnail", "api-thumbnail", methods{"GET": apiThumbnailHandler}.ServeHTTP)
+	apiRoute("/thumbnails.vtt", "api-thumbnails-vtt", methods{"GET": apiThumbnailTrackHandler}.ServeHTTP)
+	apiRoute("/reset-session", "api-reset-session", methods{"POST": apiResetSessionHandler}.ServeHTTP)
+	apiRoute("/queue", "api-queue", methods{"GET": apiQueueHandler, "POST": apiQueueActionHandler}.ServeHTTP)
+	apiRoute("/trackers", "api-trackers", methods{"GET": apiTrackersHandler, "POST": apiAddTrackersHandler}.ServeHTTP)
+	apiRoute("/peers", "api-peers", methods{"POST": apiAddPeersHandler}.ServeHTTP)
+	apiRoute("/downloads", "api-downloads", methods{"GET": apiDownloadsHandler, "POST": apiDownloadAddHandler, "DELETE": apiDownloadDeleteHandler}.ServeHTTP)
+
+	// API description for client generators; the same document on both paths
+	http.HandleFunc("/api/openapi.json", corsHandler(safeHTTPHandler("api-openapi", methods{"GET": apiOpenAPIHandler}.ServeHTTP)))
+	http.HandleFunc(apiPrefix+"/openapi.json", corsHandler(safeHTTPHandler("api-openapi", methods{"GET": apiOpenAPIHandler}.ServeHTTP)))
 
 	// Media serving routes
 	http.HandleFunc("/video", corsHandler(safeHTTPHandler("video", videoHandler)))
 	http.HandleFunc("/subtitle", corsHandler(safeHTTPHandler("subtitle", subtitleHandler)))
 	http.Handle("/subtitles/", http.StripPrefix("/subtitles/", http.FileServer(http.Dir("subtitles"))))
 
+	// Prometheus scrape endpoint
+	http.Handle("/metrics", metrics.Handler())
+
+	// Liveness and readiness probes; /healthz is kept for older deployments
+	http.HandleFunc("/livez", safeHTTPHandler("livez", livezHandler))
+	http.HandleFunc("/readyz", safeHTTPHandler("readyz", readyzHandler))
+	http.HandleFunc("/healthz", safeHTTPHandler("healthz", livezHandler))
+
+	// Admin routes
+	adminLogLevel := adminHandler(methods{
+		"GET":  apiAdminLogLevelHandler,
+		"POST": apiAdminSetLogLevelHandler,
+		"PUT":  apiAdminSetLogLevelHandler,
+	}.ServeHTTP)
+	http.HandleFunc(apiPrefix+"/admin/log-level", safeHTTPHandler("api-admin-log-level", adminLogLevel))
+	http.HandleFunc("/api/admin/log-level", safeHTTPHandler("api-admin-log-level", deprecated(apiPrefix+"/admin/log-level", adminLogLevel)))
+
+	adminTrackers := adminHandler(methods{
+		"GET":  apiAdminTrackersHandler,
+		"POST": apiAdminRefreshTrackersHandler,
+	}.ServeHTTP)
+	http.HandleFunc(apiPrefix+"/admin/trackers", safeHTTPHandler("api-admin-trackers", adminTrackers))
+
 	// Static file serving
 	http.Handle("/", http.FileServer(http.Dir("static/")))
 
@@ -241,7 +362,8 @@ func corsHandler(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -266,9 +388,26 @@ func apiStatusHandler(w http.ResponseWriter, r *http.Request) {
 		status.Magnet = magnet.String()
 		status.Status = "Streaming: " + session.Torrent.Name()
 
+		sessionLock.Lock()
+		if session.MetadataFetch != nil {
+			status.Metadata = session.MetadataFetch.progress(session.Torrent)
+			status.Status = status.Metadata.String()
+		}
+		sessionLock.Unlock()
+
+		stats := session.Torrent.Stats()
+		status.Peers = &PeerStats{
+			Active:  stats.ActivePeers,
+			Seeders: stats.ConnectedSeeders,
+			Known:   stats.TotalPeers,
+		}
+
 		if session.File != nil {
 			sessionID := getSessionID(w, r)
-			status.VideoURL = "/video?session=" + sessionID
+			// The file index changes the URL when the playlist moves on
+			status.VideoURL = fmt.Sprintf("/video?session=%s&file=%d", sessionID, session.PlaylistIndex)
+			status.PlaylistIndex = session.PlaylistIndex
+			status.PlaylistLength = len(session.Playlist)
 			completed := float64(session.File.BytesCompleted())
 			total := float64(session.File.Length())
 			if total > 0 {
@@ -276,6 +415,26 @@ func apiStatusHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			status.Downloading = status.Progress < 100
 			status.FileSize = session.File.Length()
+			status.ResumePosition = session.ResumePosition
+
+			info := fileRelease(session.File)
+			status.Title = info.DisplayTitle()
+			status.Release = &info
+
+			if session.Media != nil {
+				status.Media = session.Media
+				status.Warnings = playbackWarnings(session.Media)
+				status.AudioTracks = audioTracks(session.Media, status.VideoURL)
+				if ffmpegAvailable() {
+					infoHash := session.Torrent.InfoHash().HexString()
+					status.Poster = thumbnailURL(infoHash, session.File.Path(), "")
+					status.ThumbnailTrack = apiPrefix + "/thumbnails.vtt?infoHash=" + infoHash + "&file=" + url.QueryEscape(session.File.Path())
+				}
+			}
+			status.MediaError = session.MediaError
+			if ffmpegAvailable() {
+				status.Profiles = profileNames()
+			}
 
 			fileName := session.File.Path()
 			if dotIndex := strings.LastIndex(fileName, "."); dotIndex != -1 {
@@ -290,49 +449,135 @@ func apiStatusHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func apiStreamHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		respondJSON(w, APIResponse{Success: false, Error: "Method not allowed"})
-		return
-	}
+	log := logger.FromContext(r.Context())
 
-	var requestData struct {
-		Magnet string `json:"magnet"`
-	}
+	var spec *torrent.TorrentSpec
+	var magnet string
+	var peers []string
 
-	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
-		respondJSON(w, APIResponse{Success: false, Error: "Invalid JSON"})
-		return
-	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		var ok bool
+		if spec, magnet, ok = readTorrentUpload(w, r); !ok {
+			return
+		}
+		peers = splitList(r.FormValue("peers"))
+	} else {
+		var requestData struct {
+			Magnet string   `json:"magnet"`
+			Peers  []string `json:"peers"`
+		}
 
-	if requestData.Magnet == "" {
-		respondJSON(w, APIResponse{Success: false, Error: "Magnet link is required"})
-		return
+		if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+			respondError(w, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON")
+			return
+		}
+
+		if requestData.Magnet == "" {
+			respondError(w, http.StatusBadRequest, codeInvalidMagnet, "Magnet link is required")
+			return
+		}
+
+		// Validate magnet link format
+		if !strings.HasPrefix(requestData.Magnet, "magnet:?") {
+			respondError(w, http.StatusBadRequest, codeInvalidMagnet, "Invalid magnet link format")
+			return
+		}
+
+		var err error
+		if spec, err = torrent.TorrentSpecFromMagnetUri(requestData.Magnet); err != nil {
+			respondError(w, http.StatusBadRequest, codeInvalidMagnet, "Invalid magnet link: "+err.Error())
+			return
+		}
+		magnet, peers = requestData.Magnet, requestData.Peers
 	}
 
-	// Validate magnet link format
-	if !strings.HasPrefix(requestData.Magnet, "magnet:?") {
-		respondJSON(w, APIResponse{Success: false, Error: "Invalid magnet link format"})
+	// Explicit peers let a stream start without trackers or the DHT
+	if !validPeerAddrs(peers) {
+		respondError(w, http.StatusBadRequest, codeInvalidRequest, "peers must be host:port addresses")
 		return
 	}
+	spec.PeerAddrs = append(spec.PeerAddrs, peers...)
 
 	session := getSession(w, r)
 	sessionID := getSessionID(w, r)
+	userID := getUserID(w, r)
 
 	// Truncate magnet link for logging
-	magnetPreview := requestData.Magnet
+	magnetPreview := magnet
 	if len(magnetPreview) > 50 {
 		magnetPreview = magnetPreview[:50] + "..."
 	}
-	logger.Info("Starting stream for magnet: %s (Session: %s)", magnetPreview, sessionID)
+	log.Info("Starting stream for magnet: %s", magnetPreview, logger.SessionID(sessionID))
 
 	go func() {
 		defer recoverFromPanic("torrent-processing")
-		processTorrent(session, requestData.Magnet, sessionID)
+		processTorrent(session, spec, magnet, sessionID, userID)
 	}()
 
 	respondJSON(w, APIResponse{Success: true, Message: "Stream started"})
 }
 
+// apiStreamStopHandler stops the session's stream, including a metadata
+// fetch still in progress, but keeps the session
+func apiStreamStopHandler(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+	session := getSession(w, r)
+	sessionID := getSessionID(w, r)
+
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	if session.Torrent == nil {
+		respondError(w, http.StatusConflict, codeNoStream, "Nothing is streaming")
+		return
+	}
+	session.stopTorrent(sessionID)
+	session.Playlist = nil
+	session.StatusMsg = "Ready to stream"
+	log.Info("Stream stopped: %s", sessionID)
+
+	respondJSON(w, APIResponse{Success: true, Message: "Stream stopped"})
+}
+
+// readTorrentUpload reads a .torrent file posted as the "torrent" form
+// field. It returns the magnet link too, which is what history remembers.
+func readTorrentUpload(w http.ResponseWriter, r *http.Request) (*torrent.TorrentSpec, string, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxTorrentFileSize+1<<20)
+	file, header, err := r.FormFile("torrent")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(w, http.StatusRequestEntityTooLarge, codeFileTooLarge, "File too large (max 10MB)")
+		} else {
+			respondError(w, http.StatusBadRequest, codeInvalidRequest, "Error reading torrent file")
+		}
+		return nil, "", false
+	}
+	defer file.Close()
+
+	if header.Size > maxTorrentFileSize {
+		respondError(w, http.StatusRequestEntityTooLarge, codeFileTooLarge, "File too large (max 10MB)")
+		return nil, "", false
+	}
+
+	mi, err := metainfo.Load(file)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, codeInvalidTorrent, "Invalid torrent file")
+		return nil, "", false
+	}
+	spec, err := torrent.TorrentSpecFromMetaInfoErr(mi)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, codeInvalidTorrent, "Invalid torrent file")
+		return nil, "", false
+	}
+	magnet, err := mi.MagnetV2()
+	if err != nil {
+		respondError(w, http.StatusBadRequest, codeInvalidTorrent, "Invalid torrent file")
+		return nil, "", false
+	}
+	return spec, magnet.String(), true
+}
+
 func apiProgressHandler(w http.ResponseWriter, r *http.Request) {
 	session := getSession(w, r)
 
@@ -362,21 +607,16 @@ func apiProgressHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func apiUploadSubtitleHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		respondJSON(w, APIResponse{Success: false, Error: "Method not allowed"})
-		return
-	}
-
 	sessionID := getSessionID(w, r)
 	if sessionID == "" {
-		respondJSON(w, APIResponse{Success: false, Error: "Invalid session"})
+		respondError(w, http.StatusBadRequest, codeInvalidSession, "Invalid session")
 		return
 	}
 
 	file, header, err := r.FormFile("subtitle")
 	if err != nil {
-		logger.Error("Error reading subtitle file: %v", err)
-		respondJSON(w, APIResponse{Success: false, Error: "Error reading subtitle file"})
+		subtitleLog.Error("Error reading subtitle file: %v", err)
+		respondError(w, http.StatusBadRequest, codeInvalidRequest, "Error reading subtitle file")
 		return
 	}
 	defer file.Close()
@@ -384,15 +624,15 @@ func apiUploadSubtitleHandler(w http.ResponseWriter, r *http.Request) {
 	// Validate file extension
 	ext := strings.ToLower(filepath.Ext(header.Filename))
 	if !isSubtitleFile(ext) {
-		logger.Warn("Invalid subtitle file uploaded: %s", header.Filename)
-		respondJSON(w, APIResponse{Success: false, Error: "Invalid subtitle file format"})
+		subtitleLog.Warn("Invalid subtitle file uploaded: %s", header.Filename)
+		respondError(w, http.StatusBadRequest, codeUnsupportedSubtitle, "Invalid subtitle file format")
 		return
 	}
 
 	// Validate file size (max 5MB)
 	if header.Size > 5*1024*1024 {
-		logger.Warn("Subtitle file too large: %s (%d bytes)", header.Filename, header.Size)
-		respondJSON(w, APIResponse{Success: false, Error: "File too large (max 5MB)"})
+		subtitleLog.Warn("Subtitle file too large: %s (%d bytes)", header.Filename, header.Size)
+		respondError(w, http.StatusRequestEntityTooLarge, codeFileTooLarge, "File too large (max 5MB)")
 		return
 	}
 
@@ -405,15 +645,15 @@ func apiUploadSubtitleHandler(w http.ResponseWriter, r *http.Request) {
 
 	dst, err := os.Create(path)
 	if err != nil {
-		logger.Error("Error creating subtitle file %s: %v", path, err)
-		respondJSON(w, APIResponse{Success: false, Error: "Error saving file"})
+		subtitleLog.Error("Error creating subtitle file %s: %v", path, err)
+		respondError(w, http.StatusInternalServerError, codeInternal, "Error saving file")
 		return
 	}
 	defer dst.Close()
 
 	if _, err := io.Copy(dst, file); err != nil {
-		logger.Error("Error writing subtitle file %s: %v", path, err)
-		respondJSON(w, APIResponse{Success: false, Error: "Error saving file"})
+		subtitleLog.Error("Error writing subtitle file %s: %v", path, err)
+		respondError(w, http.StatusInternalServerError, codeInternal, "Error saving file")
 		return
 	}
 
@@ -423,37 +663,36 @@ func apiUploadSubtitleHandler(w http.ResponseWriter, r *http.Request) {
 	if session, exists := sessions[sessionID]; exists {
 		lang := detectSubtitleLanguage(header.Filename)
 		session.Subtitles = append(session.Subtitles, Subtitle{
-			Name: header.Filename,
-			Path: "/subtitles/" + sessionID + "_" + safeFilename,
-			Lang: lang,
+			Name:  header.Filename,
+			Path:  "/subtitles/" + sessionID + "_" + safeFilename,
+			Lang:  lang,
+			Group: release.Parse(header.Filename).Group,
 		})
-		logger.Info("Subtitle uploaded successfully: %s (Session: %s)", header.Filename, sessionID)
+		if session.File != nil {
+			sortSubtitlesByGroup(session.Subtitles, fileRelease(session.File).Group)
+		}
+		subtitleLog.Info("Subtitle uploaded successfully: %s (Session: %s)", header.Filename, sessionID)
 	}
 
 	respondJSON(w, APIResponse{Success: true, Message: "Subtitle uploaded successfully"})
 }
 
 func apiResetSessionHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		respondJSON(w, APIResponse{Success: false, Error: "Method not allowed"})
-		return
-	}
-
+	log := logger.FromContext(r.Context())
 	sessionID := getSessionID(w, r)
 	
 	sessionLock.Lock()
 	defer sessionLock.Unlock()
 
 	if session, exists := sessions[sessionID]; exists {
-		// Clean up torrent if any
-		if session.Torrent != nil {
-			session.Torrent.Drop()
-			logger.Info("Dropped torrent for session reset: %s", sessionID)
+		// Clean up torrent if nothing else holds it
+		if session.stopTorrent(sessionID) {
+			log.Info("Dropped torrent for session reset: %s", sessionID)
 		}
 		
 		// Remove session
 		delete(sessions, sessionID)
-		logger.Info("Session reset: %s", sessionID)
+		log.Info("Session reset: %s", sessionID)
 	}
 
 	// Clear the session cookie by setting it to expire immediately
@@ -468,82 +707,150 @@ func apiResetSessionHandler(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, APIResponse{Success: true, Message: "Session reset successfully"})
 }
 
-func processTorrent(session *UserSession, magnetLink, sessionID string) {
+// stopTorrent cancels the session's metadata fetch and lets go of its
+// torrent, reporting whether the torrent was dropped; callers must hold
+// sessionLock
+func (s *UserSession) stopTorrent(sessionID string) bool {
+	if s.MetadataFetch != nil {
+		s.MetadataFetch.cancel()
+		s.MetadataFetch = nil
+	}
+	dropped := false
+	if s.Torrent != nil {
+		dropped = queue.release(s.Torrent, sessionHolder(sessionID))
+	}
+	s.Torrent = nil
+	s.File = nil
+	s.Subtitles = nil
+	return dropped
+}
+
+func processTorrent(session *UserSession, spec *torrent.TorrentSpec, magnetLink, sessionID, userID string) {
 	sessionLock.Lock()
-	defer sessionLock.Unlock()
 
 	// Clean up existing torrent if any
-	if session.Torrent != nil {
-		session.Torrent.Drop()
-		session.Torrent = nil
-		session.File = nil
-		session.Subtitles = nil
-	}
-
+	session.stopTorrent(sessionID)
+	session.UserID = userID
+	session.ResumePosition = 0
+	session.Magnet = magnetLink
+	session.Playlist = nil
+	session.PlaylistIndex = 0
+	session.PreloadedIndex = -1
+
+	log := torrentLog.With(logger.SessionID(sessionID))
 	session.StatusMsg = "Connecting to peers..."
 
-	t, err := client.AddMagnet(magnetLink)
+	withDefaultTrackers(spec)
+
+	withPeers(spec)
+	t, _, err := client.AddTorrentSpec(spec)
 	if err != nil {
 		session.StatusMsg = "Error: " + err.Error()
-		logger.Error("Error adding magnet: %v", err)
+		log.Error("Error adding magnet: %v", err)
+		sessionLock.Unlock()
 		return
 	}
 
 	session.Torrent = t
+	queue.hold(t, sessionHolder(sessionID), priorityStreaming)
+	log = log.With(logger.InfoHash(t.InfoHash().HexString()))
 	session.StatusMsg = "Fetching torrent metadata..."
-	logger.Info("Torrent added, waiting for info...")
+	log.Info("Torrent added, waiting for info...")
 
-	// Wait for torrent info with timeout
-	select {
-	case <-t.GotInfo():
-		logger.Info("Got torrent info: %s", t.Name())
-	case <-time.After(30 * time.Second):
-		session.StatusMsg = "Timeout waiting for torrent metadata"
-		logger.Error("Timeout waiting for torrent info")
+	// The wait can take minutes, so other requests mustn't queue behind it
+	ctx, cancel := context.WithCancel(appContext)
+	defer cancel()
+	fetch := &metadataFetch{started: time.Now(), trackers: countTrackers(spec.Trackers), cancel: cancel}
+	session.MetadataFetch = fetch
+	sessionLock.Unlock()
+
+	err = waitForMetadata(ctx, t, fetch, log)
+
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+	if session.MetadataFetch != fetch {
+		// Cancelled, or another stream replaced this one
+		log.Info("Stopped waiting for torrent info")
 		return
 	}
+	session.MetadataFetch = nil
+	if err != nil {
+		metadataFetchTimeouts.Inc()
+		session.stopTorrent(sessionID)
+		session.StatusMsg = fmt.Sprintf("No torrent metadata after %s; the torrent may have no peers", time.Since(fetch.started).Round(time.Second))
+		log.Error("Gave up waiting for torrent info: %v", err)
+		return
+	}
+	metadataFetchDuration.Observe(time.Since(fetch.started).Seconds())
+	log.Info("Got torrent info: %s", t.Name())
 
 	session.StatusMsg = "Finding video file and subtitles..."
 	videoFound := false
 	subtitleCount := 0
 
+	// Season packs get every episode in order; the first one plays
+	session.Playlist = buildPlaylist(t.Files())
+	if len(session.Playlist) > 0 {
+		f := session.Playlist[0]
+		session.File = f
+		f.Download()
+		log.Info("Found video file: %s (%.2f MB)", f.Path(), float64(f.Length())/1024/1024)
+		if len(session.Playlist) > 1 {
+			log.Info("Built playlist of %d video files", len(session.Playlist))
+		}
+		videoFound = true
+	}
+
 	for _, f := range t.Files() {
 		ext := strings.ToLower(filepath.Ext(f.Path()))
 
-		if session.File == nil && isVideoFile(ext) {
-			session.File = f
-			f.Download()
-			logger.Info("Found video file: %s (%.2f MB)", f.Path(), float64(f.Length())/1024/1024)
-			videoFound = true
-			continue
-		}
-
 		if isSubtitleFile(ext) {
 			f.Download()
 			lang := detectSubtitleLanguage(f.Path())
 			session.Subtitles = append(session.Subtitles, Subtitle{
-				Name: filepath.Base(f.Path()),
-				Path: "/subtitle?session=" + sessionID + "&file=" + f.Path(),
-				Lang: lang,
+				Name:  filepath.Base(f.Path()),
+				Path:  "/subtitle?session=" + sessionID + "&file=" + f.Path(),
+				Lang:  lang,
+				Group: release.Parse(f.Path()).Group,
 			})
-			logger.Debug("Found subtitle: %s", f.Path())
+			log.Debug("Found subtitle: %s", f.Path())
 			subtitleCount++
 		}
 	}
 
 	if videoFound {
+		startProbe(session, session.File)
+
+		info := fileRelease(session.File)
+		sortSubtitlesByGroup(session.Subtitles, info.Group)
+
+		session.ResumePosition = history.recordStart(userID, HistoryEntry{
+			InfoHash: t.InfoHash().HexString(),
+			FilePath: session.File.Path(),
+			Title:    info.DisplayTitle(),
+			Magnet:   magnetLink,
+		})
+		if session.ResumePosition > 0 {
+			log.Info("Resuming at %.0fs", session.ResumePosition)
+		}
 		session.StatusMsg = "Ready to play: " + session.Torrent.Name()
-		logger.Info("Stream ready for: %s (%d subtitles found)", session.Torrent.Name(), subtitleCount)
+		log.Info("Stream ready for: %s (%d subtitles found)", session.Torrent.Name(), subtitleCount)
 	} else {
 		session.StatusMsg = "No video file found in torrent"
-		logger.Warn("No video file found in torrent: %s", session.Torrent.Name())
+		log.Warn("No video file found in torrent: %s", session.Torrent.Name())
 	}
 }
 
 // Update the videoHandler for minimal buffering
 func videoHandler(w http.ResponseWriter, r *http.Request) {
-    sessionID := getSessionID(w, r)
-    logger.Debug("Video request for session: %s", sessionID)
+    log := logger.FromContext(r.Context())
+    // External players such as mpv get the URL from /status but not the
+    // cookie, so the session in the URL wins
+    sessionID := r.URL.Query().Get("session")
+    if sessionID == "" {
+        sessionID = getSessionID(w, r)
+    }
+    log.Debug("Video request for session: %s", sessionID, logger.SessionID(sessionID))
 
     sessionLock.Lock()
     session, exists := sessions[sessionID]
@@ -556,6 +863,12 @@ func videoHandler(w http.ResponseWriter, r *http.Request) {
 
     session.LastActivity = time.Now()
 
+    // Track selection needs ffmpeg to rewrite the container
+    if remuxRequested(r) {
+        remuxHandler(w, r, session)
+        return
+    }
+
     // ===== NEW STREAMING OPTIMIZATIONS =====
     reader := session.File.NewReader()
     defer reader.Close()
@@ -571,12 +884,15 @@ func videoHandler(w http.ResponseWriter, r *http.Request) {
     w.Header().Set("Accept-Ranges", "bytes")
     w.Header().Set("Cache-Control", "no-cache")
 
+    rec := newResponseRecorder(w)
+    defer func() { videoBytesServed.Add(float64(rec.bytes)) }()
+
     // ===== ENFORCE 1MB MAX PER REQUEST =====
    // limitedReader := io.LimitReader(reader, 1<<20) // Strict 1MB limit
-    http.ServeContent(w, r, "video.mp4", time.Now(), reader)
+    http.ServeContent(rec, r, "video.mp4", time.Now(), reader)
     // ======================================
     
-    logger.Debug("Streamed video chunk (1MB max) for session: %s", sessionID)
+    log.Debug("Streamed video chunk (1MB max) for session: %s", sessionID, logger.SessionID(sessionID))
 }
 
 func subtitleHandler(w http.ResponseWriter, r *http.Request) {
@@ -584,7 +900,7 @@ func subtitleHandler(w http.ResponseWriter, r *http.Request) {
 	fileName := r.URL.Query().Get("file")
 
 	if sessionID == "" || fileName == "" {
-		logger.Warn("Invalid subtitle request - Session: %s, File: %s", sessionID, fileName)
+		subtitleLog.Warn("Invalid subtitle request - Session: %s, File: %s", sessionID, fileName)
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
@@ -594,7 +910,7 @@ func subtitleHandler(w http.ResponseWriter, r *http.Request) {
 	sessionLock.Unlock()
 
 	if !exists || session.Torrent == nil {
-		logger.Warn("Subtitle request for non-existent session: %s", sessionID)
+		subtitleLog.Warn("Subtitle request for non-existent session: %s", sessionID)
 		http.Error(w, "Session not found", http.StatusNotFound)
 		return
 	}
@@ -608,7 +924,7 @@ func subtitleHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if subFile == nil {
-		logger.Warn("Subtitle file not found: %s", fileName)
+		subtitleLog.Warn("Subtitle file not found: %s", fileName)
 		http.Error(w, "Subtitle not found", http.StatusNotFound)
 		return
 	}
@@ -618,45 +934,40 @@ func subtitleHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	if ext != ".vtt" {
-		reader := subFile.NewReader()
+		reader := fileReader(subFile)
 		defer reader.Close()
 
-		var subs *astisub.Subtitles
-		var err error
-
-		switch ext {
-		case ".srt":
-			subs, err = astisub.ReadFromSRT(reader)
-		case ".ass", ".ssa":
-			subs, err = astisub.ReadFromSSA(reader)
-		default:
-			logger.Error("Unsupported subtitle format: %s", ext)
+		subs, err := parseSubtitles(reader, ext)
+		if errors.Is(err, errUnsupportedSubtitle) {
+			subtitleConversionErrors.Inc(ext)
+			subtitleLog.Error("Unsupported subtitle format: %s", ext)
 			http.Error(w, "Unsupported subtitle format", http.StatusBadRequest)
 			return
 		}
-
 		if err != nil {
-			logger.Error("Error parsing subtitle %s: %v", fileName, err)
+			subtitleConversionErrors.Inc(ext)
+			subtitleLog.Error("Error parsing subtitle %s: %v", fileName, err)
 			http.Error(w, "Error parsing subtitle", http.StatusInternalServerError)
 			return
 		}
 
 		err = subs.WriteToWebVTT(w)
 		if err != nil {
-			logger.Error("Error converting subtitle %s: %v", fileName, err)
+			subtitleConversionErrors.Inc(ext)
+			subtitleLog.Error("Error converting subtitle %s: %v", fileName, err)
 			http.Error(w, "Error converting subtitle", http.StatusInternalServerError)
 			return
 		}
 
-		logger.Debug("Served converted subtitle: %s", fileName)
+		subtitleLog.Debug("Served converted subtitle: %s", fileName)
 		return
 	}
 
 	// Serve VTT file directly
-	reader := subFile.NewReader()
+	reader := fileReader(subFile)
 	defer reader.Close()
 	io.Copy(w, reader)
-	logger.Debug("Served VTT subtitle: %s", fileName)
+	subtitleLog.Debug("Served VTT subtitle: %s", fileName)
 }
 
 // Helper functions
@@ -686,6 +997,9 @@ func getSessionID(w http.ResponseWriter, r *http.Request) string {
 		HttpOnly: true,
 		MaxAge:   60 * 60 * 24, // 1 day
 	})
+	// Later lookups in the same request must see this ID rather than mint
+	// another one, or the session is lost along with the first cookie
+	r.AddCookie(&http.Cookie{Name: "ts_session_id", Value: sessionID})
 	return sessionID
 }
 
@@ -704,11 +1018,15 @@ func getSession(w http.ResponseWriter, r *http.Request) *UserSession {
 		StatusMsg:    "Ready to stream",
 	}
 	sessions[sessionID] = session
-	logger.Debug("Created new session: %s", sessionID)
+	sessionLog.Debug("Created new session: %s", sessionID)
 	return session
 }
 
 func respondJSON(w http.ResponseWriter, response APIResponse) {
+	// Errors carry the request ID so users can quote it in bug reports
+	if !response.Success && response.RequestID == "" {
+		response.RequestID = w.Header().Get(requestIDHeader)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -725,7 +1043,7 @@ func cleanupSessions() {
         case <-ticker.C:
             cleanupSessionBatch()
         case <-appContext.Done():
-            logger.Info("Session cleanup stopping...")
+            sessionLog.Info("Session cleanup stopping...")
             return
         }
     }
@@ -745,9 +1063,7 @@ func cleanupSessionBatch() {
             return
         default:
             if now.Sub(session.LastActivity) > 30*time.Minute {
-                if session.Torrent != nil &&  session.Torrent.Closed() !=nil {
-                    session.Torrent.Drop()
-                }
+                session.stopTorrent(sessionID)
                 delete(sessions, sessionID)
                 cleaned++
             }
@@ -755,7 +1071,7 @@ func cleanupSessionBatch() {
     }
 
     if cleaned > 0 {
-        logger.Info("Cleaned up %d inactive sessions", cleaned)
+        sessionLog.Info("Cleaned up %d inactive sessions", cleaned)
     }
 }
 
@@ -805,23 +1121,51 @@ func detectSubtitleLanguage(filename string) string {
 	return "und" // undefined
 }
 
+// sortSubtitlesByGroup moves subtitles made for the same release to the
+// front; they are timed for that exact cut and the UI picks the first one.
+func sortSubtitlesByGroup(subs []Subtitle, group string) {
+	if group == "" {
+		return
+	}
+	sort.SliceStable(subs, func(i, j int) bool {
+		return strings.EqualFold(subs[i].Group, group) && !strings.EqualFold(subs[j].Group, group)
+	})
+}
+
 // Recovery functions
 func recoverFromPanic(operation string) {
 	if r := recover(); r != nil {
+		panicsRecovered.Inc(operation)
 		logger.Warn("PANIC RECOVERED in %s: %v", operation, r)
 	}
 }
 
 func safeHTTPHandler(name string, handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := newResponseRecorder(w)
+
+		reqID := requestID(r)
+		rw.Header().Set(requestIDHeader, reqID)
+		r = r.WithContext(logger.NewContext(r.Context(), httpLog.With(logger.RequestID(reqID))))
+
 		defer func() {
 			if rec := recover(); rec != nil {
-				logger.Error("PANIC in HTTP handler %s: %v", name, rec)
-				respondJSON(w, APIResponse{Success: false, Error: "Internal Server Error"})
+				panicsRecovered.Inc("http-" + name)
+				logger.Error("PANIC in HTTP handler %s: %v", name, rec, logger.RequestID(reqID))
+				// Once the response has started its status can't change
+				if !rw.wroteHeader {
+					rw.WriteHeader(http.StatusInternalServerError)
+					respondJSON(rw, APIResponse{Success: false, Code: codeInternal, Error: "Internal Server Error"})
+				}
 			}
+			duration := time.Since(start)
+			httpRequestDuration.Observe(duration.Seconds(), name)
+			httpRequestsTotal.Inc(name, rw.statusCode())
+			logAccess(name, r, rw, reqID, duration)
 		}()
 
-		handler(w, r)
+		handler(rw, r)
 	}
 }
 
@@ -831,9 +1175,12 @@ func startHealthMonitor() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
+	recordHeartbeat()
 	for {
 		select {
 		case <-ticker.C:
+			recordHeartbeat()
+
 			sessionLock.Lock()
 			sessionCount := len(sessions)
 			sessionLock.Unlock()
@@ -847,6 +1194,17 @@ func startHealthMonitor() {
 	}
 }
 
+// watchShutdownSignals cancels the app context on Ctrl-C or SIGTERM so the
+// server shuts down cleanly and saves its state; a second signal kills it
+func watchShutdownSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	sig := <-ch
+	signal.Stop(ch)
+	logger.Warn("Received %v, shutting down", sig)
+	appCancel()
+}
+
 func waitForShutdown() {
 	select {
 	case <-appContext.Done():
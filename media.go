@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nebyat19/Torrent-Streamer/logger"
+	"github.com/Nebyat19/Torrent-Streamer/probe"
+	"github.com/anacrolix/torrent"
+)
+
+const (
+	// Headers of a file nobody seeds can take forever; give up eventually
+	probeTimeout = 2 * time.Minute
+
+	// Container headers are small, so don't pull in more than we read
+	probeReadahead = 256 << 10
+)
+
+var (
+	mediaLog = logger.Component("media")
+
+	// Probe results per info hash and file path, shared across sessions
+	probeCache     = make(map[string]*probe.Info)
+	probeCacheLock sync.Mutex
+)
+
+// Codecs current browsers decode in a <video> element
+var (
+	browserVideoCodecs = map[string]bool{"h264": true, "vp8": true, "vp9": true, "av1": true}
+	browserAudioCodecs = map[string]bool{"aac": true, "mp3": true, "opus": true, "vorbis": true, "flac": true}
+)
+
+// contextReader adapts a torrent.Reader so reads give up when ctx is done;
+// plain Read blocks until the piece arrives.
+type contextReader struct {
+	ctx context.Context
+	torrent.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	return r.Reader.ReadContext(r.ctx, p)
+}
+
+// fileReader reads a whole torrent file. The torrent's reader can return
+// bytes past the end of a file that isn't the torrent's last, so reads are
+// cut off at the file's length.
+func fileReader(f *torrent.File) io.ReadCloser {
+	reader := f.NewReader()
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(reader, f.Length()), reader}
+}
+
+// startProbe reads the selected file's container headers in the
+// background. Callers must hold sessionLock.
+func startProbe(session *UserSession, f *torrent.File) {
+	session.Media = nil
+	session.MediaError = ""
+
+	key := f.Torrent().InfoHash().HexString() + "/" + f.Path()
+	probeCacheLock.Lock()
+	cached := probeCache[key]
+	probeCacheLock.Unlock()
+	if cached != nil {
+		session.Media = cached
+		startThumbnails(f, cached)
+		return
+	}
+
+	go func() {
+		defer recoverFromPanic("probe")
+
+		log := mediaLog.With(logger.InfoHash(f.Torrent().InfoHash().HexString()))
+		info, err := probeFile(f)
+
+		sessionLock.Lock()
+		defer sessionLock.Unlock()
+
+		if err != nil {
+			log.Warn("Could not probe %s: %v", f.Path(), err)
+			if session.File == f {
+				session.MediaError = err.Error()
+			}
+			return
+		}
+
+		probeCacheLock.Lock()
+		probeCache[key] = info
+		probeCacheLock.Unlock()
+
+		log.Info("Probed %s: %s, %.0fs, %d tracks", f.Path(), info.Container, info.Duration, len(info.Tracks))
+		for _, warning := range playbackWarnings(info) {
+			log.Debug("Playback warning for %s: %s", f.Path(), warning)
+		}
+		if session.File == f {
+			session.Media = info
+		}
+		startThumbnails(f, info)
+	}()
+}
+
+func probeFile(f *torrent.File) (*probe.Info, error) {
+	ctx, cancel := context.WithTimeout(appContext, probeTimeout)
+	defer cancel()
+
+	reader := f.NewReader()
+	defer reader.Close()
+	reader.SetResponsive()
+	reader.SetReadahead(probeReadahead)
+
+	return probe.Probe(contextReader{ctx: ctx, Reader: reader}, f.Length())
+}
+
+// playbackWarnings explains why a browser is unlikely to play a file
+func playbackWarnings(info *probe.Info) []string {
+	var warnings []string
+
+	if v := info.VideoTrack(); v != nil && !browserVideoCodecs[v.Codec] {
+		if v.Codec == "hevc" {
+			warnings = append(warnings, "HEVC (H.265) video only plays in Safari and some Edge builds")
+		} else {
+			warnings = append(warnings, fmt.Sprintf("%s video is not supported by browsers", strings.ToUpper(v.Codec)))
+		}
+	}
+
+	if a := defaultAudioTrack(info); a != nil && !browserAudioCodecs[a.Codec] {
+		warnings = append(warnings, fmt.Sprintf("%s audio is not supported by browsers; the video may play without sound", strings.ToUpper(a.Codec)))
+	}
+
+	if info.Container == "matroska" {
+		warnings = append(warnings, "MKV files play in Chrome and Edge but not in Firefox or Safari")
+	}
+
+	for _, s := range info.TracksOf(probe.Subtitle) {
+		if s.Codec == "pgs" || s.Codec == "vobsub" {
+			warnings = append(warnings, "Image-based embedded subtitles can't be shown in the browser")
+			break
+		}
+	}
+
+	if (info.Container == "mp4" || info.Container == "mov") && !info.FastStart {
+		warnings = append(warnings, "The file's index is at the end, so playback waits for the last pieces")
+	}
+
+	return warnings
+}
+
+// defaultAudioTrack returns the track a player picks when none is chosen
+func defaultAudioTrack(info *probe.Info) *probe.Track {
+	var first *probe.Track
+	for n := range info.Tracks {
+		t := &info.Tracks[n]
+		if t.Type != probe.Audio {
+			continue
+		}
+		if t.Default {
+			return t
+		}
+		if first == nil {
+			first = t
+		}
+	}
+	return first
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Nebyat19/Torrent-Streamer/logger"
+	"github.com/anacrolix/torrent"
+)
+
+// maxMetadataRetries caps METADATA_RETRIES; later attempts would all be
+// cut short by the deadline anyway
+const maxMetadataRetries = 10
+
+var (
+	// The first attempt lasts metadataAttemptTimeout and every retry twice
+	// as long as the one before, all within metadataDeadline
+	metadataAttemptTimeout = envDuration("METADATA_ATTEMPT_TIMEOUT", 30*time.Second)
+	metadataRetries        = min(envInt("METADATA_RETRIES", 3), maxMetadataRetries)
+	metadataDeadline       = envDuration("METADATA_DEADLINE", 5*time.Minute)
+
+	errMetadataTimeout = errors.New("no metadata received")
+)
+
+// metadataFetch is a session's wait for torrent metadata. Fields are
+// guarded by sessionLock.
+type metadataFetch struct {
+	started  time.Time
+	attempt  int
+	trackers int
+	cancel   context.CancelFunc
+}
+
+// MetadataProgress is reported in StreamStatus while metadata is fetched
+type MetadataProgress struct {
+	Attempt  int     `json:"attempt"`
+	Attempts int     `json:"attempts"`
+	Elapsed  float64 `json:"elapsed"`
+	Deadline float64 `json:"deadline"`
+	Peers    int     `json:"peers"`
+	Trackers int     `json:"trackers"`
+}
+
+// progress describes the fetch for t; callers must hold sessionLock
+func (f *metadataFetch) progress(t *torrent.Torrent) *MetadataProgress {
+	return &MetadataProgress{
+		Attempt:  f.attempt + 1,
+		Attempts: metadataRetries + 1,
+		Elapsed:  time.Since(f.started).Seconds(),
+		Deadline: metadataDeadline.Seconds(),
+		Peers:    t.Stats().TotalPeers,
+		Trackers: f.trackers,
+	}
+}
+
+func (p *MetadataProgress) String() string {
+	msg := fmt.Sprintf("Fetching torrent metadata: %d peers, %d trackers", p.Peers, p.Trackers)
+	if p.Attempt > 1 {
+		msg += fmt.Sprintf(" (attempt %d of %d)", p.Attempt, p.Attempts)
+	}
+	return msg
+}
+
+// waitForMetadata waits for t's info, retrying with the default trackers
+// and longer waits until the retries or the deadline run out. It returns
+// ctx's error when the fetch is cancelled.
+func waitForMetadata(ctx context.Context, t *torrent.Torrent, fetch *metadataFetch, log *logger.Entry) error {
+	deadline := time.NewTimer(metadataDeadline)
+	defer deadline.Stop()
+
+	for attempt := 0; attempt <= metadataRetries; attempt++ {
+		if attempt > 0 {
+			metadataFetchRetries.Inc()
+			added := 0
+			if attempt == 1 && !offlineMode {
+				// Torrents get the default list up front unless TRACKERS_APPEND
+				// is off; add whatever they don't have yet
+				extra := missingTrackers(t.Metainfo().AnnounceList, defaultTrackers.list())
+				if len(extra) > 0 {
+					t.AddTrackers([][]string{extra})
+					added = len(extra)
+				}
+			}
+			sessionLock.Lock()
+			fetch.attempt = attempt
+			fetch.trackers += added
+			sessionLock.Unlock()
+			log.Info("No metadata yet, retrying (attempt %d of %d, %d peers known)", attempt+1, metadataRetries+1, t.Stats().TotalPeers)
+		}
+
+		timeout := time.NewTimer(metadataAttemptWait(attempt))
+		select {
+		case <-t.GotInfo():
+			timeout.Stop()
+			return nil
+		case <-ctx.Done():
+			timeout.Stop()
+			return ctx.Err()
+		case <-deadline.C:
+			timeout.Stop()
+			return errMetadataTimeout
+		case <-timeout.C:
+		}
+	}
+	return errMetadataTimeout
+}
+
+// metadataAttemptWait is how long an attempt lasts, doubling with each
+// retry but never longer than metadataDeadline
+func metadataAttemptWait(attempt int) time.Duration {
+	wait := metadataAttemptTimeout
+	for i := 0; i < attempt; i++ {
+		if wait > metadataDeadline/2 {
+			return metadataDeadline
+		}
+		wait *= 2
+	}
+	return min(wait, metadataDeadline)
+}
+
+func countTrackers(tiers [][]string) int {
+	n := 0
+	for _, tier := range tiers {
+		n += len(tier)
+	}
+	return n
+}
+
+// splitList splits a comma-separated list, skipping blanks
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
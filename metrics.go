@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Nebyat19/Torrent-Streamer/metrics"
+	"github.com/anacrolix/torrent"
+)
+
+var (
+	httpRequestDuration = metrics.NewHistogram("torrent_streamer_http_request_duration_seconds",
+		"Latency of HTTP requests by route", metrics.DefaultBuckets, "route")
+	httpRequestsTotal = metrics.NewCounter("torrent_streamer_http_requests_total",
+		"HTTP requests by route and status code", "route", "code")
+	videoBytesServed = metrics.NewCounter("torrent_streamer_video_bytes_served_total",
+		"Bytes of video written to clients by the video handler")
+	metadataFetchDuration = metrics.NewHistogram("torrent_streamer_metadata_fetch_seconds",
+		"Time taken to receive torrent metadata", []float64{1, 2, 5, 10, 15, 20, 30, 60, 120})
+	metadataFetchTimeouts = metrics.NewCounter("torrent_streamer_metadata_fetch_timeouts_total",
+		"Torrents dropped after every metadata attempt timed out")
+	metadataFetchRetries = metrics.NewCounter("torrent_streamer_metadata_fetch_retries_total",
+		"Metadata attempts after the first")
+	subtitleConversionErrors = metrics.NewCounter("torrent_streamer_subtitle_conversion_errors_total",
+		"Subtitles that failed to parse or convert to WebVTT", "format")
+	panicsRecovered = metrics.NewCounter("torrent_streamer_panics_recovered_total",
+		"Panics recovered by operation", "operation")
+	transcodesRejected = metrics.NewCounter("torrent_streamer_transcodes_rejected_total",
+		"Transcode requests refused because every slot was busy")
+)
+
+func init() {
+	metrics.NewGaugeFunc("torrent_streamer_active_sessions", "Number of active user sessions", func() float64 {
+		sessionLock.Lock()
+		defer sessionLock.Unlock()
+		return float64(len(sessions))
+	})
+
+	metrics.NewGaugeFunc("torrent_streamer_active_torrents", "Number of torrents held by the client", func() float64 {
+		if client == nil {
+			return 0
+		}
+		return float64(len(client.Torrents()))
+	})
+
+	metrics.NewGaugeFunc("torrent_streamer_peers_active", "Connected peers across all torrents", func() float64 {
+		return float64(sumTorrentStat(func(s torrent.TorrentStats) int { return s.ActivePeers }))
+	})
+
+	metrics.NewGaugeFunc("torrent_streamer_peers_total", "Known peers across all torrents", func() float64 {
+		return float64(sumTorrentStat(func(s torrent.TorrentStats) int { return s.TotalPeers }))
+	})
+
+	metrics.NewGaugeFunc("torrent_streamer_transcodes_active", "ffmpeg transcodes currently running", func() float64 {
+		return float64(len(transcodeSlots))
+	})
+
+	metrics.NewCounterFunc("torrent_streamer_client_bytes_downloaded_total", "Payload bytes downloaded by the torrent client", func() float64 {
+		if client == nil {
+			return 0
+		}
+		stats := client.Stats()
+		return float64(stats.BytesReadData.Int64())
+	})
+
+	metrics.NewCounterFunc("torrent_streamer_client_bytes_uploaded_total", "Payload bytes uploaded by the torrent client", func() float64 {
+		if client == nil {
+			return 0
+		}
+		stats := client.Stats()
+		return float64(stats.BytesWrittenData.Int64())
+	})
+}
+
+func sumTorrentStat(pick func(torrent.TorrentStats) int) int {
+	if client == nil {
+		return 0
+	}
+	total := 0
+	for _, t := range client.Torrents() {
+		total += pick(t.Stats())
+	}
+	return total
+}
+
+// responseRecorder captures the status code and body size written by a handler
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+// WriteHeader keeps the first status, which is the one the client got
+func (rr *responseRecorder) WriteHeader(code int) {
+	if rr.wroteHeader {
+		return
+	}
+	rr.status = code
+	rr.wroteHeader = true
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += int64(n)
+	return n, err
+}
+
+func (rr *responseRecorder) Flush() {
+	rr.wroteHeader = true
+	if f, ok := rr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+func (rr *responseRecorder) statusCode() string {
+	return strconv.Itoa(rr.status)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+// ByteRange is a half-open [Start, End) span of bytes within the selected file.
+type ByteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// TimeRange is a [Start, End) span of playback time in seconds.
+type TimeRange struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// PieceMap describes which parts of the selected file are already on disk.
+// Ranges is a run-length encoding of the completed pieces, so a mostly
+// downloaded file is still only a handful of entries.
+type PieceMap struct {
+	FileSize        int64       `json:"fileSize"`
+	PieceLength     int64       `json:"pieceLength"`
+	PieceCount      int         `json:"pieceCount"`
+	PiecesCompleted int         `json:"piecesCompleted"`
+	Ranges          []ByteRange `json:"ranges"`
+	Duration        float64     `json:"duration,omitempty"`
+	TimeRanges      []TimeRange `json:"timeRanges,omitempty"`
+}
+
+const pieceEventsInterval = time.Second
+
+func apiPiecesHandler(w http.ResponseWriter, r *http.Request) {
+	session := getSession(w, r)
+
+	if session.File == nil {
+		respondError(w, http.StatusConflict, codeNoFileSelected, "No file selected")
+		return
+	}
+
+	respondJSON(w, APIResponse{Success: true, Data: buildPieceMap(session.File, sessionDuration(r, session))})
+}
+
+// apiPiecesEventsHandler streams the piece map as server-sent events,
+// pushing a fresh snapshot whenever pieces of the selected file complete.
+func apiPiecesEventsHandler(w http.ResponseWriter, r *http.Request) {
+	session := getSession(w, r)
+
+	if session.File == nil {
+		http.Error(w, "No file selected", http.StatusConflict)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// The server's WriteTimeout would otherwise cut the stream after 30s
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	file := session.File
+	duration := sessionDuration(r, session)
+	sub := file.Torrent().SubscribePieceStateChanges()
+	defer sub.Close()
+
+	send := func() bool {
+		data, err := json.Marshal(buildPieceMap(file, duration))
+		if err != nil {
+			httpLog.Error("Error encoding piece map: %v", err)
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: pieces\ndata: %s\n\n", data); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	if !send() {
+		return
+	}
+
+	// Piece completions arrive in bursts, so coalesce them into at most one
+	// update per interval.
+	ticker := time.NewTicker(pieceEventsInterval)
+	defer ticker.Stop()
+	dirty := false
+
+	for {
+		select {
+		case change, ok := <-sub.Values:
+			if !ok {
+				return
+			}
+			if change.Complete && change.Index >= file.BeginPieceIndex() && change.Index < file.EndPieceIndex() {
+				dirty = true
+			}
+		case <-ticker.C:
+			if !dirty {
+				continue
+			}
+			dirty = false
+			if !send() {
+				return
+			}
+			if file.BytesCompleted() >= file.Length() {
+				httpLog.Debug("File complete, closing piece event stream")
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-appContext.Done():
+			return
+		}
+	}
+}
+
+func buildPieceMap(f *torrent.File, duration float64) PieceMap {
+	states := f.State()
+	pm := PieceMap{
+		FileSize:   f.Length(),
+		PieceCount: len(states),
+		Ranges:     []ByteRange{},
+	}
+	if info := f.Torrent().Info(); info != nil {
+		pm.PieceLength = info.PieceLength
+	}
+
+	var offset int64
+	for _, ps := range states {
+		if ps.Complete {
+			pm.PiecesCompleted++
+			if n := len(pm.Ranges); n > 0 && pm.Ranges[n-1].End == offset {
+				pm.Ranges[n-1].End += ps.Bytes
+			} else {
+				pm.Ranges = append(pm.Ranges, ByteRange{Start: offset, End: offset + ps.Bytes})
+			}
+		}
+		offset += ps.Bytes
+	}
+
+	if duration > 0 && pm.FileSize > 0 {
+		// Assume a roughly constant bitrate; good enough for a seek bar
+		pm.Duration = duration
+		scale := duration / float64(pm.FileSize)
+		for _, br := range pm.Ranges {
+			pm.TimeRanges = append(pm.TimeRanges, TimeRange{
+				Start: float64(br.Start) * scale,
+				End:   float64(br.End) * scale,
+			})
+		}
+	}
+
+	return pm
+}
+
+// sessionDuration prefers the player's reported duration and falls back to
+// the one read from the container headers.
+func sessionDuration(r *http.Request, session *UserSession) float64 {
+	if duration := requestDuration(r); duration > 0 {
+		return duration
+	}
+	if session.Media != nil {
+		return session.Media.Duration
+	}
+	return 0
+}
+
+// requestDuration returns the playback duration reported by the player, if any.
+func requestDuration(r *http.Request) float64 {
+	duration, err := strconv.ParseFloat(r.URL.Query().Get("duration"), 64)
+	if err != nil || duration < 0 || math.IsInf(duration, 0) || math.IsNaN(duration) {
+		return 0
+	}
+	return duration
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Nebyat19/Torrent-Streamer/logger"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// playIdleTimeout ends a standalone stream without a player once nothing
+// has read from it for this long
+const playIdleTimeout = 30 * time.Second
+
+const playUsage = `Usage: torrent-streamer play [flags] <magnet | file.torrent>
+
+Streams the torrent's video file on a local port without the web UI and
+exits when playback stops.
+
+Flags:
+`
+
+// runPlay implements "torrent-streamer play" and returns the exit code
+func runPlay(args []string) int {
+	fs := flag.NewFlagSet("play", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), playUsage)
+		fs.PrintDefaults()
+	}
+	player := fs.String("player", os.Getenv("PLAYER"), `command that opens the URL, e.g. "mpv --fs" (PLAYER); without one the URL is printed`)
+	addr := fs.String("listen", "127.0.0.1:0", "address to serve the video on; the port is random by default")
+	index := fs.Int("file", 0, "playlist position of the video to play, for season packs")
+	wait := fs.Duration("wait", 2*time.Minute, "how long to wait for torrent metadata")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	// Server logs would bury the URL, which is the only thing on stdout;
+	// LOG_LEVEL still applies when set
+	logger.GetLogger().SetConsole(os.Stderr)
+	if os.Getenv("LOG_LEVEL") == "" {
+		logger.SetLevel(logger.WARN)
+	}
+
+	ctx, stop := signal.NotifyContext(appContext, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := defaultTrackers.load(); err != nil {
+		fmt.Fprintf(os.Stderr, "play: tracker list: %v\n", err)
+	}
+	if err := initializeTorrentClient(); err != nil {
+		fmt.Fprintf(os.Stderr, "play: %v\n", err)
+		return 1
+	}
+	defer func() {
+		saveDHTNodes()
+		client.Close()
+	}()
+
+	if err := play(ctx, fs.Arg(0), *addr, *index, *wait, *player); err != nil {
+		fmt.Fprintf(os.Stderr, "play: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func play(ctx context.Context, source, addr string, index int, wait time.Duration, player string) error {
+	spec, err := playSpec(source)
+	if err != nil {
+		return err
+	}
+	withDefaultTrackers(spec)
+	withPeers(spec)
+	t, _, err := client.AddTorrentSpec(spec)
+	if err != nil {
+		return err
+	}
+	defer t.Drop()
+
+	fmt.Fprintln(os.Stderr, "Fetching torrent metadata...")
+	select {
+	case <-t.GotInfo():
+	case <-time.After(wait):
+		return fmt.Errorf("no metadata after %s", wait)
+	case <-ctx.Done():
+		return nil
+	}
+
+	// The same choice processTorrent makes: episodes in order, samples last
+	playlist := buildPlaylist(t.Files())
+	if len(playlist) == 0 {
+		return errors.New("no video file found in torrent")
+	}
+	if index < 0 || index >= len(playlist) {
+		return fmt.Errorf("file %d out of range; the torrent has %d video files", index, len(playlist))
+	}
+	f := playlist[index]
+	f.Download()
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	ps := &playServer{file: f}
+	srv := &http.Server{Handler: ps, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	url := fmt.Sprintf("http://%s/%s", ln.Addr(), filepath.Base(f.Path()))
+	fmt.Fprintf(os.Stderr, "Playing %s (%.2f MB)\n", fileRelease(f).DisplayTitle(), float64(f.Length())/1024/1024)
+	fmt.Println(url)
+
+	if player == "" {
+		ps.waitIdle(ctx)
+		return nil
+	}
+
+	command := strings.Fields(player)
+	cmd := exec.CommandContext(ctx, command[0], append(command[1:], url)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("%s: %w", command[0], err)
+	}
+	return nil
+}
+
+func playSpec(source string) (*torrent.TorrentSpec, error) {
+	if strings.HasPrefix(source, "magnet:") {
+		return torrent.TorrentSpecFromMagnetUri(source)
+	}
+	mi, err := metainfo.LoadFromFile(source)
+	if err != nil {
+		return nil, err
+	}
+	return torrent.TorrentSpecFromMetaInfoErr(mi)
+}
+
+// playServer serves one torrent file to a local player and tracks whether
+// anyone is still reading it
+type playServer struct {
+	file     *torrent.File
+	active   atomic.Int32
+	lastRead atomic.Int64
+}
+
+func (ps *playServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ps.active.Add(1)
+	defer func() {
+		ps.lastRead.Store(time.Now().UnixNano())
+		ps.active.Add(-1)
+	}()
+
+	reader := ps.file.NewReader()
+	defer reader.Close()
+	reader.SetResponsive()
+	reader.SetReadahead(4 << 20)
+	http.ServeContent(w, r, filepath.Base(ps.file.Path()), time.Time{}, contextReader{ctx: r.Context(), Reader: reader})
+}
+
+// waitIdle returns once a player has come and gone, or ctx is done
+func (ps *playServer) waitIdle(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		last := ps.lastRead.Load()
+		if last != 0 && ps.active.Load() == 0 && time.Since(time.Unix(0, last)) > playIdleTimeout {
+			return
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/Nebyat19/Torrent-Streamer/logger"
+	"github.com/Nebyat19/Torrent-Streamer/release"
+	"github.com/anacrolix/torrent"
+)
+
+// PlaylistItem describes one video file of a multi-file torrent
+type PlaylistItem struct {
+	Index   int          `json:"index"`
+	Name    string       `json:"name"`
+	Title   string       `json:"title"`
+	Path    string       `json:"path"`
+	Size    int64        `json:"size"`
+	Season  int          `json:"season,omitempty"`
+	Episode int          `json:"episode,omitempty"`
+	Current bool         `json:"current"`
+	Release release.Info `json:"release"`
+}
+
+const (
+	// How much of the next episode to fetch ahead of time
+	preloadBytes = 16 << 20
+
+	// Start preloading this close to the end of the current episode
+	preloadLeadSeconds = 120
+)
+
+var samplePattern = regexp.MustCompile(`(?i)(^|[ ._\-\[(/])sample([ ._\-\])/]|$)`)
+
+// fileRelease describes a file using both its own name and the torrent's.
+// Season packs carry the show details in the torrent name while each file
+// carries its episode number, so the file's fields win where present.
+func fileRelease(f *torrent.File) release.Info {
+	info := release.Parse(f.Torrent().Name())
+	fi := release.Parse(filepath.Base(f.Path()))
+
+	if fi.Season > 0 || fi.Episode > 0 {
+		info.Season, info.Episode = fi.Season, fi.Episode
+		if info.Title == "" {
+			info.Title = fi.Title
+		}
+	}
+	if info.Title == "" || len(f.Torrent().Files()) == 1 {
+		info.Title = fi.Title
+	}
+	if info.Year == 0 {
+		info.Year = fi.Year
+	}
+	info.Resolution = firstNonEmpty(info.Resolution, fi.Resolution)
+	info.Source = firstNonEmpty(info.Source, fi.Source)
+	info.Codec = firstNonEmpty(info.Codec, fi.Codec)
+	info.Audio = firstNonEmpty(info.Audio, fi.Audio)
+	info.Group = firstNonEmpty(info.Group, fi.Group)
+	if len(info.Languages) == 0 {
+		info.Languages = fi.Languages
+	}
+	return info
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// naturalLess compares strings so that "Episode 2" sorts before "Episode 10"
+func naturalLess(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		ra, rb := rune(a[0]), rune(b[0])
+		if unicode.IsDigit(ra) && unicode.IsDigit(rb) {
+			na, restA := leadingDigits(a)
+			nb, restB := leadingDigits(b)
+			ta, tb := strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if len(ta) != len(tb) {
+				return len(ta) < len(tb)
+			}
+			if ta != tb {
+				return ta < tb
+			}
+			a, b = restA, restB
+			continue
+		}
+		if ra != rb {
+			return ra < rb
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func leadingDigits(s string) (digits, rest string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// buildPlaylist returns the torrent's video files in viewing order. Sample
+// clips are dropped unless they are all there is.
+func buildPlaylist(files []*torrent.File) []*torrent.File {
+	var videos, samples []*torrent.File
+	for _, f := range files {
+		if !isVideoFile(strings.ToLower(filepath.Ext(f.Path()))) {
+			continue
+		}
+		if samplePattern.MatchString(f.Path()) {
+			samples = append(samples, f)
+			continue
+		}
+		videos = append(videos, f)
+	}
+	if len(videos) == 0 {
+		videos = samples
+	}
+
+	sort.SliceStable(videos, func(i, j int) bool {
+		si, ei, okI := release.ParseEpisode(filepath.Base(videos[i].Path()))
+		sj, ej, okJ := release.ParseEpisode(filepath.Base(videos[j].Path()))
+		if okI && okJ && (si != sj || ei != ej) {
+			if si != sj {
+				return si < sj
+			}
+			return ei < ej
+		}
+		return naturalLess(videos[i].Path(), videos[j].Path())
+	})
+	return videos
+}
+
+func playlistItems(session *UserSession) []PlaylistItem {
+	items := make([]PlaylistItem, 0, len(session.Playlist))
+	for i, f := range session.Playlist {
+		info := fileRelease(f)
+		items = append(items, PlaylistItem{
+			Index:   i,
+			Name:    filepath.Base(f.Path()),
+			Title:   info.DisplayTitle(),
+			Path:    f.Path(),
+			Size:    f.Length(),
+			Season:  info.Season,
+			Episode: info.Episode,
+			Current: f == session.File,
+			Release: info,
+		})
+	}
+	return items
+}
+
+// selectPlaylistItem switches the session to another file of the same
+// torrent. Callers must hold sessionLock.
+func selectPlaylistItem(session *UserSession, index int) bool {
+	if index < 0 || index >= len(session.Playlist) {
+		return false
+	}
+
+	next := session.Playlist[index]
+	if next == session.File {
+		return true
+	}
+
+	if session.File != nil {
+		session.File.SetPriority(torrent.PiecePriorityNone)
+	}
+	session.File = next
+	session.PlaylistIndex = index
+	session.PreloadedIndex = -1
+	next.Download()
+	startProbe(session, next)
+
+	session.ResumePosition = history.recordStart(session.UserID, HistoryEntry{
+		InfoHash: session.Torrent.InfoHash().HexString(),
+		FilePath: next.Path(),
+		Title:    fileRelease(next).DisplayTitle(),
+		Magnet:   session.Magnet,
+	})
+	session.StatusMsg = "Ready to play: " + session.Torrent.Name()
+	torrentLog.Info("Switched to playlist item %d: %s", index, next.Path())
+	return true
+}
+
+// maybePreloadNext fetches the start and end of the next episode once
+// playback nears the end of the current one, so auto-play starts instantly.
+// The tail is included because MP4 indexes and MKV cues often live there.
+func maybePreloadNext(session *UserSession, position, duration float64) {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	nextIndex := session.PlaylistIndex + 1
+	if duration <= 0 || nextIndex >= len(session.Playlist) || session.PreloadedIndex == nextIndex {
+		return
+	}
+	if duration-position > preloadLeadSeconds && position < duration*completedThreshold {
+		return
+	}
+
+	next := session.Playlist[nextIndex]
+	info := next.Torrent().Info()
+	if info == nil || info.PieceLength == 0 {
+		return
+	}
+
+	begin, end := next.BeginPieceIndex(), next.EndPieceIndex()
+	headEnd := begin + int((preloadBytes+info.PieceLength-1)/info.PieceLength)
+	if headEnd > end {
+		headEnd = end
+	}
+	next.Torrent().DownloadPieces(begin, headEnd)
+	if end-1 >= headEnd {
+		next.Torrent().DownloadPieces(end-1, end)
+	}
+
+	session.PreloadedIndex = nextIndex
+	torrentLog.Debug("Preloading next episode: %s", next.Path())
+}
+
+func apiPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	session := getSession(w, r)
+
+	sessionLock.Lock()
+	items := playlistItems(session)
+	sessionLock.Unlock()
+	respondJSON(w, APIResponse{Success: true, Data: items})
+}
+
+// apiPlaylistActionHandler moves through the playlist: next, previous or
+// select by index
+func apiPlaylistActionHandler(w http.ResponseWriter, r *http.Request) {
+	session := getSession(w, r)
+
+	var requestData struct {
+		Action string `json:"action"`
+		Index  int    `json:"index"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		respondError(w, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	if len(session.Playlist) == 0 {
+		respondError(w, http.StatusConflict, codeNoPlaylist, "No playlist")
+		return
+	}
+
+	index := session.PlaylistIndex
+	switch requestData.Action {
+	case "next":
+		index++
+	case "previous":
+		index--
+	case "select":
+		index = requestData.Index
+	default:
+		respondError(w, http.StatusBadRequest, codeInvalidRequest, "Unknown action")
+		return
+	}
+
+	if !selectPlaylistItem(session, index) {
+		respondError(w, http.StatusNotFound, codeNotFound, "No such playlist item")
+		return
+	}
+
+	logger.FromContext(r.Context()).Info("Playlist %s -> item %d", requestData.Action, index)
+	respondJSON(w, APIResponse{Success: true, Data: playlistItems(session)})
+}
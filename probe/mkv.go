@@ -0,0 +1,459 @@
+package probe
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+)
+
+// Matroska element IDs, with their length markers kept as in the spec
+const (
+	idEBML          = 0x1A45DFA3
+	idDocType       = 0x4282
+	idSegment       = 0x18538067
+	idSeekHead      = 0x114D9B74
+	idSeek          = 0x4DBB
+	idSeekID        = 0x53AB
+	idSeekPosition  = 0x53AC
+	idInfo          = 0x1549A966
+	idTimecodeScale = 0x2AD7B1
+	idDuration      = 0x4489
+	idTracks        = 0x1654AE6B
+	idTrackEntry    = 0xAE
+	idTrackNumber   = 0xD7
+	idTrackType     = 0x83
+	idCodecID       = 0x86
+	idLanguage      = 0x22B59C
+	idLanguageBCP47 = 0x22B59D
+	idName          = 0x536E
+	idFlagDefault   = 0x88
+	idFlagForced    = 0x55AA
+	idDefaultDur    = 0x23E383
+	idVideo         = 0xE0
+	idPixelWidth    = 0xB0
+	idPixelHeight   = 0xBA
+	idAudio         = 0xE1
+	idSamplingFreq  = 0xB5
+	idChannels      = 0x9F
+	idCluster       = 0x1F43B675
+)
+
+const unknownSize = -1
+
+var mkvCodecs = map[string]string{
+	"V_MPEG4/ISO/AVC":  "h264",
+	"V_MPEGH/ISO/HEVC": "hevc",
+	"V_AV1":            "av1",
+	"V_VP8":            "vp8",
+	"V_VP9":            "vp9",
+	"V_MPEG4/ISO/ASP":  "mpeg4",
+	"V_MPEG2":          "mpeg2",
+	"A_AC3":            "ac3",
+	"A_EAC3":           "eac3",
+	"A_TRUEHD":         "truehd",
+	"A_OPUS":           "opus",
+	"A_VORBIS":         "vorbis",
+	"A_FLAC":           "flac",
+	"A_MPEG/L3":        "mp3",
+	"S_TEXT/UTF8":      "subrip",
+	"S_TEXT/ASS":       "ass",
+	"S_TEXT/SSA":       "ass",
+	"S_ASS":            "ass",
+	"S_SSA":            "ass",
+	"S_TEXT/WEBVTT":    "webvtt",
+	"S_HDMV/PGS":       "pgs",
+	"S_VOBSUB":         "vobsub",
+}
+
+func mkvCodec(codecID string) string {
+	if c, ok := mkvCodecs[codecID]; ok {
+		return c
+	}
+	switch {
+	case strings.HasPrefix(codecID, "A_AAC"):
+		return "aac"
+	case strings.HasPrefix(codecID, "A_DTS"):
+		return "dts"
+	}
+	return strings.ToLower(codecID)
+}
+
+// probeMatroska reads the Segment's Info and Tracks elements, following the
+// SeekHead when they are not at the front of the file.
+func probeMatroska(r io.ReadSeeker, size int64) (*Info, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	er := &ebmlReader{r: r}
+
+	id, headerSize, err := er.element()
+	if err != nil {
+		return nil, err
+	}
+	if id != idEBML || headerSize == unknownSize {
+		return nil, ErrMalformed
+	}
+	header, err := er.payload(headerSize)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{Container: "matroska"}
+	eachElement(header, func(id uint32, p []byte) {
+		if id == idDocType && string(p) == "webm" {
+			info.Container = "webm"
+		}
+	})
+
+	id, segmentSize, err := er.element()
+	if err != nil {
+		return nil, err
+	}
+	if id != idSegment {
+		return nil, ErrMalformed
+	}
+	segmentStart := er.pos
+	segmentEnd := size
+	if segmentSize != unknownSize && segmentStart+segmentSize < size {
+		segmentEnd = segmentStart + segmentSize
+	}
+
+	var (
+		infoData, tracksData []byte
+		seeks                = make(map[uint32]int64)
+	)
+
+	for n := 0; er.pos < segmentEnd && n < maxElements; n++ {
+		if infoData != nil && tracksData != nil {
+			break
+		}
+		id, elementSize, err := er.element()
+		if err != nil {
+			return nil, err
+		}
+		// Clusters hold the media; anything we still need is found via SeekHead
+		if id == idCluster || elementSize == unknownSize {
+			break
+		}
+
+		switch id {
+		case idSeekHead:
+			data, err := er.payload(elementSize)
+			if err != nil {
+				return nil, err
+			}
+			parseSeekHead(data, seeks)
+		case idInfo:
+			if infoData, err = er.payload(elementSize); err != nil {
+				return nil, err
+			}
+		case idTracks:
+			if tracksData, err = er.payload(elementSize); err != nil {
+				return nil, err
+			}
+		default:
+			if err := er.skip(elementSize); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if infoData == nil {
+		infoData = er.seekElement(segmentStart, seeks, idInfo)
+	}
+	if tracksData == nil {
+		tracksData = er.seekElement(segmentStart, seeks, idTracks)
+	}
+	if tracksData == nil {
+		return nil, ErrMalformed
+	}
+
+	parseSegmentInfo(info, infoData)
+	eachElement(tracksData, func(id uint32, p []byte) {
+		if id == idTrackEntry {
+			if t, ok := parseTrackEntry(p); ok {
+				info.Tracks = append(info.Tracks, t)
+			}
+		}
+	})
+	return info, nil
+}
+
+func parseSeekHead(data []byte, seeks map[uint32]int64) {
+	eachElement(data, func(id uint32, p []byte) {
+		if id != idSeek {
+			return
+		}
+		var target uint32
+		var position int64 = -1
+		eachElement(p, func(id uint32, p []byte) {
+			switch id {
+			case idSeekID:
+				target = uint32(readUint(p))
+			case idSeekPosition:
+				position = int64(readUint(p))
+			}
+		})
+		if target != 0 && position >= 0 {
+			seeks[target] = position
+		}
+	})
+}
+
+func parseSegmentInfo(info *Info, data []byte) {
+	scale := uint64(1000000)
+	var duration float64
+	eachElement(data, func(id uint32, p []byte) {
+		switch id {
+		case idTimecodeScale:
+			if s := readUint(p); s > 0 {
+				scale = s
+			}
+		case idDuration:
+			duration = readFloat(p)
+		}
+	})
+	if d := duration * float64(scale) / 1e9; validDuration(d) {
+		info.Duration = d
+	}
+}
+
+func parseTrackEntry(data []byte) (Track, bool) {
+	// Matroska defaults: FlagDefault is 1 and Language is "eng" when absent
+	t := Track{Default: true, Language: "eng"}
+	var kind uint64
+	var frameDuration uint64
+	bcp47 := ""
+
+	eachElement(data, func(id uint32, p []byte) {
+		switch id {
+		case idTrackNumber:
+			t.ID = int(readUint(p))
+		case idTrackType:
+			kind = readUint(p)
+		case idCodecID:
+			t.CodecID = readText(p)
+		case idLanguage:
+			t.Language = readText(p)
+		case idLanguageBCP47:
+			bcp47 = readText(p)
+		case idName:
+			t.Name = readText(p)
+		case idFlagDefault:
+			t.Default = readUint(p) != 0
+		case idFlagForced:
+			t.Forced = readUint(p) != 0
+		case idDefaultDur:
+			frameDuration = readUint(p)
+		case idVideo:
+			eachElement(p, func(id uint32, p []byte) {
+				switch id {
+				case idPixelWidth:
+					t.Width = int(readUint(p))
+				case idPixelHeight:
+					t.Height = int(readUint(p))
+				}
+			})
+		case idAudio:
+			t.SampleRate = 8000
+			t.Channels = 1
+			eachElement(p, func(id uint32, p []byte) {
+				switch id {
+				case idSamplingFreq:
+					t.SampleRate = int(readFloat(p))
+				case idChannels:
+					t.Channels = int(readUint(p))
+				}
+			})
+		}
+	})
+
+	switch kind {
+	case 1:
+		t.Type = Video
+	case 2:
+		t.Type = Audio
+	case 17:
+		t.Type = Subtitle
+	default:
+		return t, false
+	}
+	if bcp47 != "" {
+		t.Language = bcp47
+	}
+	if t.Language == "und" {
+		t.Language = ""
+	}
+	t.Codec = mkvCodec(t.CodecID)
+	if t.Type == Video && frameDuration > 0 {
+		t.FrameRate = roundRate(1e9 / float64(frameDuration))
+	}
+	return t, true
+}
+
+// ebmlReader reads element headers from a stream, tracking the offset
+type ebmlReader struct {
+	r   io.ReadSeeker
+	pos int64
+}
+
+func (er *ebmlReader) readByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(er.r, b[:]); err != nil {
+		return 0, err
+	}
+	er.pos++
+	return b[0], nil
+}
+
+// vint reads a variable-length integer. IDs keep their length marker;
+// sizes have it stripped, and an all-ones size means unknown.
+func (er *ebmlReader) vint(keepMarker bool) (int64, error) {
+	first, err := er.readByte()
+	if err != nil {
+		return 0, err
+	}
+	length := 1
+	for mask := byte(0x80); length <= 8 && first&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, ErrMalformed
+	}
+
+	value := uint64(first)
+	if !keepMarker {
+		value &= uint64(0xFF) >> length
+	}
+	allOnes := value == uint64(0xFF)>>length
+	for i := 1; i < length; i++ {
+		b, err := er.readByte()
+		if err != nil {
+			return 0, err
+		}
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	if !keepMarker && allOnes {
+		return unknownSize, nil
+	}
+	if value > math.MaxInt64 {
+		return 0, ErrMalformed
+	}
+	return int64(value), nil
+}
+
+func (er *ebmlReader) element() (id uint32, size int64, err error) {
+	rawID, err := er.vint(true)
+	if err != nil {
+		return 0, 0, err
+	}
+	if size, err = er.vint(false); err != nil {
+		return 0, 0, err
+	}
+	return uint32(rawID), size, nil
+}
+
+func (er *ebmlReader) payload(size int64) ([]byte, error) {
+	if size < 0 || size > maxHeaderBytes {
+		return nil, ErrMalformed
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(er.r, buf); err != nil {
+		return nil, err
+	}
+	er.pos += size
+	return buf, nil
+}
+
+func (er *ebmlReader) skip(size int64) error {
+	pos, err := er.r.Seek(size, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	er.pos = pos
+	return nil
+}
+
+// seekElement reads a top-level element at the position SeekHead recorded
+// for it, or returns nil
+func (er *ebmlReader) seekElement(segmentStart int64, seeks map[uint32]int64, want uint32) []byte {
+	position, ok := seeks[want]
+	if !ok {
+		return nil
+	}
+	if _, err := er.r.Seek(segmentStart+position, io.SeekStart); err != nil {
+		return nil
+	}
+	er.pos = segmentStart + position
+	id, size, err := er.element()
+	if err != nil || id != want {
+		return nil
+	}
+	data, err := er.payload(size)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// eachElement calls fn for every element directly inside data
+func eachElement(data []byte, fn func(id uint32, payload []byte)) {
+	for len(data) > 0 {
+		id, n := bufVint(data, true)
+		if n == 0 {
+			return
+		}
+		data = data[n:]
+		size, m := bufVint(data, false)
+		if m == 0 || size > uint64(len(data)-m) {
+			return
+		}
+		data = data[m:]
+		fn(uint32(id), data[:size])
+		data = data[size:]
+	}
+}
+
+func bufVint(data []byte, keepMarker bool) (uint64, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	length := 1
+	for mask := byte(0x80); length <= 8 && data[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 || length > len(data) {
+		return 0, 0
+	}
+	value := uint64(data[0])
+	if !keepMarker {
+		value &= uint64(0xFF) >> length
+	}
+	for i := 1; i < length; i++ {
+		value = value<<8 | uint64(data[i])
+	}
+	return value, length
+}
+
+func readUint(p []byte) uint64 {
+	var v uint64
+	for _, b := range p {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+func readFloat(p []byte) float64 {
+	switch len(p) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(p)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(p))
+	}
+	return 0
+}
+
+func readText(p []byte) string {
+	return strings.TrimRight(string(p), "\x00")
+}
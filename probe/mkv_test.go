@@ -0,0 +1,184 @@
+package probe
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// el encodes an EBML element with an 8-byte size, so lengths don't depend
+// on the payload
+func el(id uint32, payload ...[]byte) []byte {
+	p := cat(payload...)
+	return cat(ebmlID(id), be64(uint64(len(p))|1<<56), p)
+}
+
+// elUnknown encodes an element of unknown size
+func elUnknown(id uint32) []byte {
+	return cat(ebmlID(id), be64(1<<57-1))
+}
+
+func ebmlID(id uint32) []byte {
+	b := be32(id)
+	for len(b) > 1 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+func str(s string) []byte { return []byte(s) }
+
+func uintEl(id uint32, v uint64) []byte { return el(id, be64(v)) }
+
+func ebmlHeader(docType string) []byte {
+	return el(idEBML, el(idDocType, str(docType)))
+}
+
+func segmentInfo() []byte {
+	return el(idInfo, uintEl(idTimecodeScale, 1000000), el(idDuration, f64(5000)))
+}
+
+func tracks() []byte {
+	return el(idTracks,
+		el(idTrackEntry,
+			uintEl(idTrackNumber, 1), uintEl(idTrackType, 1), el(idCodecID, str("V_MPEG4/ISO/AVC")),
+			uintEl(idDefaultDur, 40000000),
+			el(idVideo, uintEl(idPixelWidth, 1920), uintEl(idPixelHeight, 1080))),
+		el(idTrackEntry,
+			uintEl(idTrackNumber, 2), uintEl(idTrackType, 2), el(idCodecID, str("A_OPUS")),
+			el(idLanguage, str("jpn")), uintEl(idFlagDefault, 0),
+			el(idAudio, el(idSamplingFreq, f64(48000)), uintEl(idChannels, 2))),
+		el(idTrackEntry,
+			uintEl(idTrackNumber, 3), uintEl(idTrackType, 17), el(idCodecID, str("S_TEXT/UTF8")),
+			el(idLanguage, str("und")), el(idName, str("Signs")), uintEl(idFlagForced, 1)),
+		// Unknown track types are skipped
+		el(idTrackEntry, uintEl(idTrackNumber, 4), uintEl(idTrackType, 33)),
+	)
+}
+
+var mkvTracks = []Track{
+	{Index: 0, ID: 1, Type: Video, Codec: "h264", CodecID: "V_MPEG4/ISO/AVC", Language: "eng", Default: true, Width: 1920, Height: 1080, FrameRate: 25},
+	{Index: 0, ID: 2, Type: Audio, Codec: "opus", CodecID: "A_OPUS", Language: "jpn", Channels: 2, SampleRate: 48000},
+	{Index: 0, ID: 3, Type: Subtitle, Codec: "subrip", CodecID: "S_TEXT/UTF8", Name: "Signs", Default: true, Forced: true},
+}
+
+func seekEntry(id uint32, position int) []byte {
+	return el(idSeek, el(idSeekID, ebmlID(id)), uintEl(idSeekPosition, uint64(position)))
+}
+
+// seekHeadFile puts Info and Tracks after the first Cluster, where they
+// can only be found through the SeekHead
+func seekHeadFile() []byte {
+	cluster := el(idCluster, zeros(64))
+	seekHeadLen := len(el(idSeekHead, seekEntry(idInfo, 0), seekEntry(idTracks, 0)))
+	infoPos := seekHeadLen + len(cluster)
+	tracksPos := infoPos + len(segmentInfo())
+	seekHead := el(idSeekHead, seekEntry(idInfo, infoPos), seekEntry(idTracks, tracksPos))
+	return cat(ebmlHeader("matroska"), el(idSegment, seekHead, cluster, segmentInfo(), tracks()))
+}
+
+func TestProbeMatroska(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want *Info
+	}{
+		{"headers up front", cat(ebmlHeader("webm"), el(idSegment, segmentInfo(), tracks(), el(idCluster, zeros(64)))),
+			&Info{Container: "webm", Duration: 5, Tracks: mkvTracks}},
+		{"headers via SeekHead", seekHeadFile(),
+			&Info{Container: "matroska", Duration: 5, Tracks: mkvTracks}},
+		{"unknown segment size", cat(ebmlHeader("matroska"), elUnknown(idSegment), segmentInfo(), tracks()),
+			&Info{Container: "matroska", Duration: 5, Tracks: mkvTracks}},
+		{"no info", cat(ebmlHeader("matroska"), el(idSegment, tracks())),
+			&Info{Container: "matroska", Tracks: mkvTracks}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := probeBytes(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeMatroskaBadDuration(t *testing.T) {
+	// Durations that aren't finite or are absurdly long are left out
+	tests := []struct {
+		name string
+		info []byte
+	}{
+		{"infinite", el(idInfo, el(idDuration, f64(math.Inf(1))))},
+		{"negative infinite", el(idInfo, el(idDuration, f64(math.Inf(-1))))},
+		{"NaN", el(idInfo, el(idDuration, f64(math.NaN())))},
+		{"overflowing the scale", el(idInfo, uintEl(idTimecodeScale, math.MaxUint64), el(idDuration, f64(math.MaxFloat64)))},
+		{"longer than a week", el(idInfo, uintEl(idTimecodeScale, 1000000000), el(idDuration, f64(maxDuration+1)))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := probeBytes(cat(ebmlHeader("matroska"), el(idSegment, tt.info, tracks())))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Duration != 0 {
+				t.Errorf("got duration %v, want 0", got.Duration)
+			}
+		})
+	}
+}
+
+func TestProbeMatroskaMalformed(t *testing.T) {
+	header := ebmlHeader("matroska")
+	full := cat(header, el(idSegment, segmentInfo(), tracks()))
+	seekHead := seekHeadFile()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated tracks", full[:len(full)-20]},
+		{"truncated SeekHead target", seekHead[:len(seekHead)-20]},
+		{"header of unknown size", cat(elUnknown(idEBML), zeros(16))},
+		{"header past the end", cat(ebmlID(idEBML), be64(1<<56|100), zeros(8))},
+		{"not a segment", cat(header, el(idCluster, zeros(16)))},
+		{"invalid vint", cat(header, ebmlID(idSegment), zeros(9))},
+		{"tracks larger than the limit", cat(header, el(idSegment, cat(ebmlID(idTracks), be64(1<<56|maxHeaderBytes+1))))},
+		{"no tracks", cat(header, el(idSegment, segmentInfo(), el(idCluster, zeros(16))))},
+		{"SeekHead past the end", cat(header, el(idSegment, el(idSeekHead, seekEntry(idTracks, 1<<40)), el(idCluster)))},
+		{"SeekHead to the wrong element", cat(header, el(idSegment, el(idSeekHead, seekEntry(idTracks, 0)), el(idCluster)))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := probeBytes(tt.data)
+			if err == nil {
+				t.Fatalf("got %+v, want an error", info)
+			}
+		})
+	}
+}
+
+func TestProbeMatroskaManyElements(t *testing.T) {
+	// More empty Void elements than maxElements must stop the walk
+	var voids []byte
+	for i := 0; i <= maxElements; i++ {
+		voids = append(voids, 0xEC, 0x80)
+	}
+	data := cat(ebmlHeader("matroska"), el(idSegment, voids, tracks()))
+	if _, err := probeBytes(data); !errors.Is(err, ErrMalformed) {
+		t.Errorf("got %v, want ErrMalformed", err)
+	}
+}
+
+func TestEachElementOverrun(t *testing.T) {
+	// A child claiming more bytes than its parent holds ends the walk
+	data := cat(uintEl(idTrackNumber, 1), ebmlID(idTrackType), be64(1<<56|1000), zeros(4))
+	var ids []uint32
+	eachElement(data, func(id uint32, p []byte) { ids = append(ids, id) })
+	if !reflect.DeepEqual(ids, []uint32{idTrackNumber}) {
+		t.Errorf("got %x, want only the TrackNumber", ids)
+	}
+}
@@ -0,0 +1,290 @@
+package probe
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+var mp4Codecs = map[string]string{
+	"avc1": "h264", "avc3": "h264",
+	"hvc1": "hevc", "hev1": "hevc",
+	"av01": "av1",
+	"vp08": "vp8", "vp09": "vp9",
+	"mp4v": "mpeg4",
+	"mp4a": "aac",
+	".mp3": "mp3",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	"Opus": "opus",
+	"fLaC": "flac",
+	"dtsc": "dts", "dtsh": "dts", "dtsl": "dts", "dtse": "dts",
+	"tx3g": "mov_text",
+	"wvtt": "webvtt",
+	"stpp": "ttml",
+}
+
+var mp4Handlers = map[string]string{
+	"vide": Video,
+	"soun": Audio,
+	"sbtl": Subtitle,
+	"subt": Subtitle,
+	"text": Subtitle,
+	"clcp": Subtitle,
+}
+
+func isMP4Box(typ string) bool {
+	switch typ {
+	case "ftyp", "moov", "mdat", "free", "skip", "wide", "pdin":
+		return true
+	}
+	return false
+}
+
+// probeMP4 walks the top-level boxes until it finds moov, seeking over
+// everything else so mdat is never read.
+func probeMP4(r io.ReadSeeker, size int64) (*Info, error) {
+	info := &Info{Container: "mp4"}
+	seenMdat := false
+
+	var offset int64
+	for n := 0; offset+8 <= size && n < maxElements; n++ {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		var hdr [16]byte
+		if _, err := io.ReadFull(r, hdr[:8]); err != nil {
+			return nil, err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(hdr[:4]))
+		typ := string(hdr[4:8])
+		headerLen := int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - offset
+		case 1:
+			if _, err := io.ReadFull(r, hdr[8:16]); err != nil {
+				return nil, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			headerLen = 16
+		}
+		if boxSize < headerLen {
+			return nil, ErrMalformed
+		}
+
+		switch typ {
+		case "ftyp":
+			if strings.HasPrefix(readString(r, 4), "qt") {
+				info.Container = "mov"
+			}
+		case "mdat":
+			seenMdat = true
+		case "moov":
+			if boxSize-headerLen > maxHeaderBytes {
+				return nil, ErrMalformed
+			}
+			moov := make([]byte, boxSize-headerLen)
+			if _, err := io.ReadFull(r, moov); err != nil {
+				return nil, err
+			}
+			parseMoov(info, moov)
+			info.FastStart = !seenMdat
+			return info, nil
+		}
+		offset += boxSize
+	}
+	return nil, ErrMalformed
+}
+
+func readString(r io.Reader, n int) string {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return ""
+	}
+	return string(buf)
+}
+
+// eachBox calls fn for every box directly inside data
+func eachBox(data []byte, fn func(typ string, payload []byte)) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		typ := string(data[4:8])
+		headerLen := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerLen = 16
+		}
+		if size < headerLen || size > uint64(len(data)) {
+			return
+		}
+		fn(typ, data[headerLen:size])
+		data = data[size:]
+	}
+}
+
+func parseMoov(info *Info, moov []byte) {
+	eachBox(moov, func(typ string, payload []byte) {
+		switch typ {
+		case "mvhd":
+			timescale, duration := mediaTimes(payload)
+			if d := float64(duration) / float64(timescale); timescale > 0 && validDuration(d) {
+				info.Duration = d
+			}
+		case "trak":
+			if t, ok := parseTrak(payload); ok {
+				info.Tracks = append(info.Tracks, t)
+			}
+		}
+	})
+}
+
+// mediaTimes reads timescale and duration from an mvhd or mdhd payload
+func mediaTimes(p []byte) (timescale uint32, duration uint64) {
+	if len(p) < 4 {
+		return 0, 0
+	}
+	if p[0] == 1 {
+		if len(p) < 32 {
+			return 0, 0
+		}
+		return binary.BigEndian.Uint32(p[20:24]), binary.BigEndian.Uint64(p[24:32])
+	}
+	if len(p) < 20 {
+		return 0, 0
+	}
+	return binary.BigEndian.Uint32(p[12:16]), uint64(binary.BigEndian.Uint32(p[16:20]))
+}
+
+func parseTrak(trak []byte) (Track, bool) {
+	var (
+		t         Track
+		timescale uint32
+		stsd      []byte
+		stts      []byte
+	)
+
+	eachBox(trak, func(typ string, payload []byte) {
+		switch typ {
+		case "tkhd":
+			if len(payload) >= 4 {
+				t.Default = payload[3]&0x1 != 0
+			}
+			idOffset := 12
+			if len(payload) > 0 && payload[0] == 1 {
+				idOffset = 20
+			}
+			if len(payload) >= idOffset+4 {
+				t.ID = int(binary.BigEndian.Uint32(payload[idOffset:]))
+			}
+			// Presentation size is the last two 16.16 fixed-point fields
+			if len(payload) >= 8 {
+				t.Width = int(binary.BigEndian.Uint32(payload[len(payload)-8:]) >> 16)
+				t.Height = int(binary.BigEndian.Uint32(payload[len(payload)-4:]) >> 16)
+			}
+		case "mdia":
+			eachBox(payload, func(typ string, payload []byte) {
+				switch typ {
+				case "mdhd":
+					timescale, _ = mediaTimes(payload)
+					t.Language = mdhdLanguage(payload)
+				case "hdlr":
+					if len(payload) >= 12 {
+						t.Type = mp4Handlers[string(payload[8:12])]
+					}
+					if len(payload) > 24 {
+						name := strings.TrimRight(string(payload[24:]), "\x00")
+						if !strings.HasSuffix(name, "Handler") && !strings.HasPrefix(name, "Core Media") {
+							t.Name = strings.TrimSpace(name)
+						}
+					}
+				case "minf":
+					eachBox(payload, func(typ string, payload []byte) {
+						if typ == "stbl" {
+							eachBox(payload, func(typ string, payload []byte) {
+								switch typ {
+								case "stsd":
+									stsd = payload
+								case "stts":
+									stts = payload
+								}
+							})
+						}
+					})
+				}
+			})
+		}
+	})
+
+	if t.Type == "" {
+		return t, false
+	}
+
+	// stsd: version/flags, entry count, then sample entries
+	if len(stsd) >= 16 {
+		entry := stsd[8:]
+		t.CodecID = string(entry[4:8])
+		t.Codec = mp4Codecs[t.CodecID]
+		if t.Codec == "" {
+			t.Codec = strings.TrimSpace(t.CodecID)
+		}
+		switch t.Type {
+		case Video:
+			if len(entry) >= 36 {
+				t.Width = int(binary.BigEndian.Uint16(entry[32:34]))
+				t.Height = int(binary.BigEndian.Uint16(entry[34:36]))
+			}
+		case Audio:
+			if len(entry) >= 36 {
+				t.Channels = int(binary.BigEndian.Uint16(entry[24:26]))
+				t.SampleRate = int(binary.BigEndian.Uint32(entry[32:36]) >> 16)
+			}
+		}
+	}
+	if t.Type != Video {
+		t.Width, t.Height = 0, 0
+	}
+
+	// The first stts entry's sample delta is the frame duration for
+	// constant frame rate video, which is nearly all of it
+	if t.Type == Video && timescale > 0 && len(stts) >= 16 {
+		if delta := binary.BigEndian.Uint32(stts[12:16]); delta > 0 {
+			t.FrameRate = roundRate(float64(timescale) / float64(delta))
+		}
+	}
+
+	return t, true
+}
+
+// mdhdLanguage decodes the packed ISO 639-2/T code in an mdhd payload
+func mdhdLanguage(p []byte) string {
+	offset := 20
+	if len(p) > 0 && p[0] == 1 {
+		offset = 32
+	}
+	if len(p) < offset+2 {
+		return ""
+	}
+	packed := binary.BigEndian.Uint16(p[offset:])
+	lang := []byte{
+		byte(packed>>10&0x1f) + 0x60,
+		byte(packed>>5&0x1f) + 0x60,
+		byte(packed&0x1f) + 0x60,
+	}
+	for _, c := range lang {
+		if c < 'a' || c > 'z' {
+			return ""
+		}
+	}
+	return string(lang)
+}
+
+func roundRate(fps float64) float64 {
+	return float64(int(fps*1000+0.5)) / 1000
+}
@@ -0,0 +1,161 @@
+package probe
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func box(typ string, payload ...[]byte) []byte {
+	p := cat(payload...)
+	return cat(be32(uint32(8+len(p))), []byte(typ), p)
+}
+
+func mvhd(timescale, duration uint32) []byte {
+	p := zeros(100)
+	put(p, 12, be32(timescale))
+	put(p, 16, be32(duration))
+	return box("mvhd", p)
+}
+
+func tkhd(id uint32, enabled bool) []byte {
+	p := zeros(84)
+	if enabled {
+		p[3] = 1
+	}
+	put(p, 12, be32(id))
+	return box("tkhd", p)
+}
+
+// mdhd packs lang as three 5-bit letters
+func mdhd(timescale uint32, lang string) []byte {
+	p := zeros(24)
+	put(p, 12, be32(timescale))
+	packed := uint16(lang[0]-0x60)<<10 | uint16(lang[1]-0x60)<<5 | uint16(lang[2]-0x60)
+	put(p, 20, be16(packed))
+	return box("mdhd", p)
+}
+
+func hdlr(handler, name string) []byte {
+	return box("hdlr", zeros(8), []byte(handler), zeros(12), []byte(name+"\x00"))
+}
+
+func stbl(entry, stts []byte) []byte {
+	return box("minf", box("stbl", box("stsd", zeros(4), be32(1), entry), stts))
+}
+
+func videoTrak(id uint32) []byte {
+	entry := put(put(put(zeros(86), 0, be32(86)), 4, []byte("avc1")), 32, cat(be16(640), be16(360)))
+	stts := box("stts", zeros(4), be32(1), be32(250), be32(1000))
+	return box("trak", tkhd(id, true), box("mdia", mdhd(25000, "eng"), hdlr("vide", "Main video"), stbl(entry, stts)))
+}
+
+func audioTrak(id uint32) []byte {
+	entry := put(put(put(put(zeros(36), 0, be32(36)), 4, []byte("mp4a")), 24, be16(2)), 32, be32(48000<<16))
+	return box("trak", tkhd(id, false), box("mdia", mdhd(48000, "jpn"), hdlr("soun", "SoundHandler"), stbl(entry, nil)))
+}
+
+func moov() []byte {
+	return box("moov", mvhd(1000, 5000), videoTrak(1), audioTrak(2))
+}
+
+var mp4Tracks = []Track{
+	{Index: 0, ID: 1, Type: Video, Codec: "h264", CodecID: "avc1", Language: "eng", Name: "Main video", Default: true, Width: 640, Height: 360, FrameRate: 25},
+	{Index: 0, ID: 2, Type: Audio, Codec: "aac", CodecID: "mp4a", Language: "jpn", Channels: 2, SampleRate: 48000},
+}
+
+func TestProbeMP4(t *testing.T) {
+	ftyp := box("ftyp", []byte("isom"), zeros(4))
+	mdat := box("mdat", zeros(32))
+
+	tests := []struct {
+		name string
+		data []byte
+		want *Info
+	}{
+		{"fast start", cat(ftyp, moov(), mdat), &Info{Container: "mp4", Duration: 5, Tracks: mp4Tracks, FastStart: true}},
+		{"moov at the end", cat(ftyp, mdat, moov()), &Info{Container: "mp4", Duration: 5, Tracks: mp4Tracks}},
+		{"quicktime", cat(box("ftyp", []byte("qt  "), zeros(4)), moov()), &Info{Container: "mov", Duration: 5, Tracks: mp4Tracks, FastStart: true}},
+		{"64-bit box size", cat(ftyp, be32(1), []byte("mdat"), be64(48), zeros(32), moov()), &Info{Container: "mp4", Duration: 5, Tracks: mp4Tracks}},
+		{"no tracks", cat(ftyp, box("moov", mvhd(600, 1200))), &Info{Container: "mp4", Duration: 2, FastStart: true}},
+		// Children overrunning their parent are ignored, not read past
+		{"oversized child box", cat(ftyp, box("moov", mvhd(1000, 5000), be32(1<<20), []byte("trak"), zeros(16))), &Info{Container: "mp4", Duration: 5, FastStart: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := probeBytes(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeMP4BadDuration(t *testing.T) {
+	// 64-bit mvhd with a duration far beyond a week
+	long := zeros(112)
+	long[0] = 1
+	put(long, 20, be32(1))
+	put(long, 24, be64(1<<62))
+
+	tests := []struct {
+		name string
+		mvhd []byte
+	}{
+		{"no timescale", mvhd(0, 5000)},
+		{"longer than a week", mvhd(1, maxDuration+1)},
+		{"64-bit duration", box("mvhd", long)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := probeBytes(cat(box("ftyp", []byte("isom"), zeros(4)), box("moov", tt.mvhd)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Duration != 0 {
+				t.Errorf("got duration %v, want 0", got.Duration)
+			}
+		})
+	}
+}
+
+func TestProbeMP4Malformed(t *testing.T) {
+	ftyp := box("ftyp", []byte("isom"), zeros(4))
+	full := cat(ftyp, moov())
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated moov", full[:len(full)-40]},
+		{"no moov", cat(ftyp, box("mdat", zeros(32)))},
+		{"box smaller than its header", cat(ftyp, be32(4), []byte("free"), zeros(8))},
+		{"moov larger than the limit", cat(ftyp, be32(0xFFFFFFF0), []byte("moov"), zeros(8))},
+		{"64-bit size overflowing", cat(ftyp, be32(1), []byte("mdat"), be64(1<<63), zeros(8))},
+		{"64-bit size past the end", cat(ftyp, be32(1), []byte("mdat"), be64(1<<62), zeros(8))},
+		{"box to end of file", cat(ftyp, be32(0), []byte("free"), zeros(8))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := probeBytes(tt.data)
+			if err == nil {
+				t.Fatalf("got %+v, want an error", info)
+			}
+		})
+	}
+}
+
+func TestProbeMP4ManyBoxes(t *testing.T) {
+	// More boxes than maxElements must stop the walk
+	data := box("ftyp", []byte("isom"), zeros(4))
+	for i := 0; i <= maxElements; i++ {
+		data = append(data, box("free")...)
+	}
+	data = append(data, moov()...)
+	if _, err := probeBytes(data); !errors.Is(err, ErrMalformed) {
+		t.Errorf("got %v, want ErrMalformed", err)
+	}
+}
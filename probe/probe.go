@@ -0,0 +1,122 @@
+package probe
+
+import (
+	"errors"
+	"io"
+)
+
+// Track types
+const (
+	Video    = "video"
+	Audio    = "audio"
+	Subtitle = "subtitle"
+)
+
+// Track is one elementary stream inside a container
+type Track struct {
+	// Index counts tracks of the same type from zero, in container order
+	Index     int     `json:"index"`
+	ID        int     `json:"id"`
+	Type      string  `json:"type"`
+	Codec     string  `json:"codec"`
+	CodecID   string  `json:"codecId"`
+	Language  string  `json:"language,omitempty"`
+	Name      string  `json:"name,omitempty"`
+	Default   bool    `json:"default,omitempty"`
+	Forced    bool    `json:"forced,omitempty"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	FrameRate float64 `json:"frameRate,omitempty"`
+	Channels  int     `json:"channels,omitempty"`
+	// SampleRate is in Hz
+	SampleRate int `json:"sampleRate,omitempty"`
+}
+
+// Info describes a media file as read from its container headers
+type Info struct {
+	Container string  `json:"container"`
+	Duration  float64 `json:"duration,omitempty"`
+	Tracks    []Track `json:"tracks"`
+
+	// FastStart is set for MP4 files whose index precedes the media data,
+	// so playback can begin before the tail of the file is downloaded.
+	FastStart bool `json:"fastStart,omitempty"`
+}
+
+var (
+	ErrUnsupported = errors.New("probe: unsupported container")
+	ErrMalformed   = errors.New("probe: malformed container")
+)
+
+// Limits keep a corrupt or hostile header from making us read the whole
+// file or allocate gigabytes.
+const (
+	maxHeaderBytes = 64 << 20
+	maxElements    = 1 << 16
+
+	// maxDuration is a week in seconds; longer durations are corrupt
+	maxDuration = 7 * 24 * 60 * 60
+)
+
+// Probe reads container headers from r, which holds size bytes. Only the
+// parts of the file that describe the streams are read.
+func Probe(r io.ReadSeeker, size int64) (*Info, error) {
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+
+	var (
+		info *Info
+		err  error
+	)
+	switch {
+	case head[0] == 0x1A && head[1] == 0x45 && head[2] == 0xDF && head[3] == 0xA3:
+		info, err = probeMatroska(r, size)
+	case isMP4Box(string(head[4:8])):
+		info, err = probeMP4(r, size)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	numberTracks(info.Tracks)
+	return info, nil
+}
+
+// TracksOf returns the tracks of one type
+func (i *Info) TracksOf(kind string) []Track {
+	var tracks []Track
+	for _, t := range i.Tracks {
+		if t.Type == kind {
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks
+}
+
+// VideoTrack returns the first video track, if any
+func (i *Info) VideoTrack() *Track {
+	for n := range i.Tracks {
+		if i.Tracks[n].Type == Video {
+			return &i.Tracks[n]
+		}
+	}
+	return nil
+}
+
+// validDuration reports whether d is a usable duration in seconds. NaN
+// fails both comparisons.
+func validDuration(d float64) bool {
+	return d > 0 && d <= maxDuration
+}
+
+func numberTracks(tracks []Track) {
+	counts := make(map[string]int)
+	for n := range tracks {
+		tracks[n].Index = counts[tracks[n].Type]
+		counts[tracks[n].Type]++
+	}
+}
@@ -0,0 +1,54 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+func probeBytes(data []byte) (*Info, error) {
+	return Probe(bytes.NewReader(data), int64(len(data)))
+}
+
+func be16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func be32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func be64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+func f64(v float64) []byte { return be64(math.Float64bits(v)) }
+
+func cat(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+func zeros(n int) []byte { return make([]byte, n) }
+
+// put writes b into buf at offset and returns buf
+func put(buf []byte, offset int, b []byte) []byte {
+	copy(buf[offset:], b)
+	return buf
+}
+
+func TestProbeUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"text", []byte("hello, this is not a video")},
+		{"zeros", zeros(64)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := probeBytes(tt.data); !errors.Is(err, ErrUnsupported) {
+				t.Errorf("got %v, want ErrUnsupported", err)
+			}
+		})
+	}
+}
+
+func TestProbeShortInput(t *testing.T) {
+	for _, data := range [][]byte{nil, {0x1A, 0x45}, []byte("ftyp")} {
+		if _, err := probeBytes(data); err == nil {
+			t.Errorf("%x: got no error", data)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Nebyat19/Torrent-Streamer/logger"
+	"github.com/anacrolix/torrent"
+)
+
+// queuePriority orders torrents competing for the client's slots; higher
+// priorities get them first
+type queuePriority int
+
+const (
+	priorityBackground queuePriority = iota
+	priorityUser
+	priorityStreaming
+)
+
+var priorityNames = map[queuePriority]string{
+	priorityBackground: "background",
+	priorityUser:       "user",
+	priorityStreaming:  "streaming",
+}
+
+func (p queuePriority) String() string {
+	return priorityNames[p]
+}
+
+// parseDownloadPriority reads a download's priority; streaming is reserved
+// for sessions. Empty means user-queued.
+func parseDownloadPriority(s string) (queuePriority, bool) {
+	switch s {
+	case "", "user":
+		return priorityUser, true
+	case "background":
+		return priorityBackground, true
+	}
+	return 0, false
+}
+
+// Queue entry states
+const (
+	queueMetadata    = "metadata"
+	queueDownloading = "downloading"
+	queueQueued      = "queued"
+	queuePaused      = "paused"
+)
+
+const queueScheduleInterval = 2 * time.Second
+
+var (
+	queueLog = logger.Component("queue")
+
+	// Limits on torrents transferring data and torrents fetching metadata;
+	// 0 means no limit. Streaming torrents always run but use up slots.
+	maxActiveTorrents  = envInt("MAX_ACTIVE_TORRENTS", 5)
+	maxMetadataFetches = envInt("MAX_METADATA_FETCHES", 3)
+)
+
+// queueEntry is a torrent in the client and whoever holds on to it: sessions
+// streaming it and downloads fetching it
+type queueEntry struct {
+	t       *torrent.Torrent
+	holders map[string]queuePriority
+	state   string
+
+	// paused is set by the user; stopped is whether the scheduler or a
+	// pause stopped the torrent, which keeps its connection limit in maxConns
+	paused   bool
+	stopped  bool
+	maxConns int
+}
+
+func (e *queueEntry) priority() queuePriority {
+	p := priorityBackground
+	for _, hp := range e.holders {
+		if hp > p {
+			p = hp
+		}
+	}
+	return p
+}
+
+// queueManager decides which torrents may use the network. It also owns
+// their lifetime: a torrent is dropped once its last holder releases it.
+type queueManager struct {
+	mu sync.Mutex
+	// In queue order; priority still comes first when scheduling
+	entries []*queueEntry
+}
+
+var queue = &queueManager{}
+
+func sessionHolder(sessionID string) string { return "session:" + sessionID }
+
+func downloadHolder(jobID string) string { return "download:" + jobID }
+
+// callerHolders returns the holders a request acts for: its session and
+// its user's downloads. It returns nil for admins, who may see and act on
+// every torrent.
+func callerHolders(w http.ResponseWriter, r *http.Request) map[string]bool {
+	if isAdminRequest(r) {
+		return nil
+	}
+	holders := map[string]bool{sessionHolder(getSessionID(w, r)): true}
+	for _, id := range downloads.jobIDs(getUserID(w, r)) {
+		holders[downloadHolder(id)] = true
+	}
+	return holders
+}
+
+// heldBy reports whether any of holders holds e; nil holders means anyone
+func (e *queueEntry) heldBy(holders map[string]bool) bool {
+	if holders == nil {
+		return true
+	}
+	for holder := range e.holders {
+		if holders[holder] {
+			return true
+		}
+	}
+	return false
+}
+
+// hold registers holder's interest in t at a priority; holding again
+// updates the priority
+func (q *queueManager) hold(t *torrent.Torrent, holder string, p queuePriority) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e := q.find(t)
+	if e == nil {
+		e = &queueEntry{t: t, holders: make(map[string]queuePriority)}
+		q.entries = append(q.entries, e)
+	}
+	e.holders[holder] = p
+	q.scheduleLocked()
+}
+
+// release drops holder's interest in t and drops the torrent once nothing
+// holds it, reporting whether it did
+func (q *queueManager) release(t *torrent.Torrent, holder string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e := q.find(t)
+	if e == nil {
+		return false
+	}
+	delete(e.holders, holder)
+	if len(e.holders) > 0 {
+		q.scheduleLocked()
+		return false
+	}
+
+	for i, entry := range q.entries {
+		if entry == e {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			break
+		}
+	}
+	t.Drop()
+	q.scheduleLocked()
+	return true
+}
+
+// reset forgets every entry without dropping them, for when the client
+// itself is closed
+func (q *queueManager) reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.entries = nil
+}
+
+// find returns t's entry; callers must hold q.mu
+func (q *queueManager) find(t *torrent.Torrent) *queueEntry {
+	for _, e := range q.entries {
+		if e.t == t {
+			return e
+		}
+	}
+	return nil
+}
+
+// heldBy reports whether t is in the queue and held by any of holders
+func (q *queueManager) heldBy(t *torrent.Torrent, holders map[string]bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e := q.find(t)
+	return e != nil && e.heldBy(holders)
+}
+
+func (q *queueManager) findHash(infoHash string) *queueEntry {
+	for _, e := range q.entries {
+		if e.t.InfoHash().HexString() == infoHash {
+			return e
+		}
+	}
+	return nil
+}
+
+// ordered returns the entries in scheduling order; callers must hold q.mu
+func (q *queueManager) ordered() []*queueEntry {
+	order := append([]*queueEntry(nil), q.entries...)
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].priority() > order[j].priority()
+	})
+	return order
+}
+
+// scheduleLocked starts and stops torrents to fit the limits, filling slots
+// in priority and queue order; callers must hold q.mu
+func (q *queueManager) scheduleLocked() {
+	active, fetching := 0, 0
+	for _, e := range q.ordered() {
+		streaming := e.priority() == priorityStreaming
+		run := false
+
+		switch {
+		case e.paused:
+			e.state = queuePaused
+		case e.t.Info() == nil:
+			run = streaming || underLimit(fetching, maxMetadataFetches)
+			if run {
+				fetching++
+				e.state = queueMetadata
+			}
+		default:
+			run = streaming || underLimit(active, maxActiveTorrents)
+			if run {
+				active++
+				e.state = queueDownloading
+			}
+		}
+		if !run && !e.paused {
+			e.state = queueQueued
+		}
+
+		if run && e.stopped {
+			e.t.SetMaxEstablishedConns(e.maxConns)
+			e.t.AllowDataDownload()
+			e.stopped = false
+			queueLog.Debug("Started %s", e.t.Name(), logger.InfoHash(e.t.InfoHash().HexString()))
+		} else if !run && !e.stopped {
+			// Without connections a torrent fetches neither data nor metadata
+			e.maxConns = e.t.SetMaxEstablishedConns(0)
+			e.t.DisallowDataDownload()
+			e.stopped = true
+			queueLog.Debug("Stopped %s (%s)", e.t.Name(), e.state, logger.InfoHash(e.t.InfoHash().HexString()))
+		}
+	}
+}
+
+func underLimit(n, limit int) bool {
+	return limit == 0 || n < limit
+}
+
+func runQueueScheduler() {
+	defer recoverFromPanic("queue-scheduler")
+
+	ticker := time.NewTicker(queueScheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Metadata arriving moves torrents between the limits
+			queue.mu.Lock()
+			queue.scheduleLocked()
+			queue.mu.Unlock()
+		case <-appContext.Done():
+			return
+		}
+	}
+}
+
+// QueueEntry is a torrent as shown by /api/v1/queue
+type QueueEntry struct {
+	Position       int    `json:"position"`
+	InfoHash       string `json:"infoHash"`
+	Name           string `json:"name"`
+	Priority       string `json:"priority"`
+	State          string `json:"state"`
+	BytesCompleted int64  `json:"bytesCompleted"`
+	BytesTotal     int64  `json:"bytesTotal"`
+	Peers          int    `json:"peers"`
+}
+
+// QueueStatus is the response of /api/v1/queue
+type QueueStatus struct {
+	MaxActive          int          `json:"maxActive"`
+	MaxMetadataFetches int          `json:"maxMetadataFetches"`
+	Entries            []QueueEntry `json:"entries"`
+}
+
+// status lists the entries held by holders, or every entry when holders
+// is nil. Positions are within the whole queue.
+func (q *queueManager) status(holders map[string]bool) QueueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	status := QueueStatus{
+		MaxActive:          maxActiveTorrents,
+		MaxMetadataFetches: maxMetadataFetches,
+		Entries:            []QueueEntry{},
+	}
+	for i, e := range q.ordered() {
+		if !e.heldBy(holders) {
+			continue
+		}
+		entry := QueueEntry{
+			Position:       i,
+			InfoHash:       e.t.InfoHash().HexString(),
+			Name:           e.t.Name(),
+			Priority:       e.priority().String(),
+			State:          e.state,
+			BytesCompleted: e.t.BytesCompleted(),
+			Peers:          e.t.Stats().ActivePeers,
+		}
+		if e.t.Info() != nil {
+			entry.BytesTotal = e.t.Length()
+		}
+		status.Entries = append(status.Entries, entry)
+	}
+	return status
+}
+
+// move puts an entry at a position in the queue. Priority still comes
+// first, so an entry can't move ahead of one with a higher priority.
+func (q *queueManager) move(e *queueEntry, position int) {
+	order := q.ordered()
+	for i, entry := range order {
+		if entry == e {
+			order = append(order[:i], order[i+1:]...)
+			break
+		}
+	}
+	order = append(order[:position], append([]*queueEntry{e}, order[position:]...)...)
+	q.entries = order
+}
+
+// apiQueueHandler lists the caller's torrents, or all of them for admins
+func apiQueueHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, APIResponse{Success: true, Data: queue.status(callerHolders(w, r))})
+}
+
+// apiQueueActionHandler pauses, resumes or moves one of the caller's
+// torrents; admins may act on any
+func apiQueueActionHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		InfoHash string `json:"infoHash"`
+		Action   string `json:"action"`
+		Position int    `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		respondError(w, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON")
+		return
+	}
+	holders := callerHolders(w, r)
+
+	queue.mu.Lock()
+	e := queue.findHash(requestData.InfoHash)
+	if e == nil || !e.heldBy(holders) {
+		queue.mu.Unlock()
+		respondError(w, http.StatusNotFound, codeNotFound, "Torrent is not in the queue")
+		return
+	}
+	switch requestData.Action {
+	case "pause":
+		e.paused = true
+	case "resume":
+		e.paused = false
+	case "move":
+		if requestData.Position < 0 || requestData.Position >= len(queue.entries) {
+			queue.mu.Unlock()
+			respondError(w, http.StatusBadRequest, codeInvalidRequest, "Position out of range")
+			return
+		}
+		queue.move(e, requestData.Position)
+	default:
+		queue.mu.Unlock()
+		respondError(w, http.StatusBadRequest, codeInvalidRequest, "Unknown action")
+		return
+	}
+	queue.scheduleLocked()
+	queue.mu.Unlock()
+
+	queueLog.Info("Queue %s: %s", requestData.Action, e.t.Name(), logger.InfoHash(requestData.InfoHash))
+	respondJSON(w, APIResponse{Success: true, Data: queue.status(holders)})
+}
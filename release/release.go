@@ -0,0 +1,345 @@
+package release
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Info is the structured form of a scene/P2P release name such as
+// "The.Bourne.Ultimatum.2007.1080p.BluRay.x264-[YTS.MX]"
+type Info struct {
+	Title      string   `json:"title"`
+	Year       int      `json:"year,omitempty"`
+	Season     int      `json:"season,omitempty"`
+	Episode    int      `json:"episode,omitempty"`
+	Resolution string   `json:"resolution,omitempty"`
+	Source     string   `json:"source,omitempty"`
+	Codec      string   `json:"codec,omitempty"`
+	Audio      string   `json:"audio,omitempty"`
+	Group      string   `json:"group,omitempty"`
+	Languages  []string `json:"languages,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+}
+
+// DisplayTitle renders a clean title for the UI, e.g. "The Bourne Ultimatum (2007)"
+// or "Show Name S01E02".
+func (i Info) DisplayTitle() string {
+	title := i.Title
+	switch {
+	case i.Season > 0 && i.Episode > 0:
+		title += fmt.Sprintf(" S%02dE%02d", i.Season, i.Episode)
+	case i.Season > 0:
+		title += fmt.Sprintf(" Season %d", i.Season)
+	case i.Episode > 0:
+		title += fmt.Sprintf(" - %02d", i.Episode)
+	}
+	if i.Year > 0 {
+		title += fmt.Sprintf(" (%d)", i.Year)
+	}
+	return strings.TrimSpace(title)
+}
+
+// Names are matched after dots and underscores become spaces, so every
+// pattern is wrapped in these separators rather than \b, which would also
+// split on the dashes in "WEB-DL" or "DTS-HD".
+const (
+	pre  = `(?:^|[\s\[\(\-])`
+	post = `(?:$|[\s\]\)\-])`
+)
+
+type tokenPattern struct {
+	re    *regexp.Regexp
+	value func(m string) string
+}
+
+func token(expr string, value func(m string) string) tokenPattern {
+	return tokenPattern{re: regexp.MustCompile(`(?i)` + pre + `(` + expr + `)` + post), value: value}
+}
+
+func fixed(v string) func(string) string {
+	return func(string) string { return v }
+}
+
+var (
+	episodePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)` + pre + `(s(\d{1,2})[\s\-]?e(\d{1,3})(?:[\s\-]?e\d{1,3})*)` + post),
+		regexp.MustCompile(`(?i)` + pre + `((\d{1,2})x(\d{1,3}))` + post),
+		regexp.MustCompile(`(?i)` + pre + `(season\s?(\d{1,2})\s+episode\s?(\d{1,3}))` + post),
+	}
+	seasonPattern      = regexp.MustCompile(`(?i)` + pre + `((?:s|season\s?)(\d{1,2}))` + post)
+	animeEpisode       = regexp.MustCompile(`\s-\s(\d{1,3})(?:v\d)?(?:\s|$)`)
+	yearPattern        = regexp.MustCompile(pre + `((19\d{2}|20\d{2}))` + post)
+	trailingGroup      = regexp.MustCompile(`-\s*\[?([A-Za-z0-9][A-Za-z0-9.&_ ]*?)\]?\s*$`)
+	trailingBracket    = regexp.MustCompile(`\[([^\]]+)\]\s*$`)
+	leadingBracket     = regexp.MustCompile(`^\s*\[([^\]]+)\]`)
+	bracketedNoise     = regexp.MustCompile(`[\[\(][^\]\)]*[\]\)]`)
+	whitespace         = regexp.MustCompile(`\s+`)
+	trailingSeparators = regexp.MustCompile(`[\s\-\[\(]+$`)
+
+	resolutionPatterns = []tokenPattern{
+		token(`2160p|4k|uhd`, fixed("2160p")),
+		token(`1080p|1080i`, fixed("1080p")),
+		token(`720p`, fixed("720p")),
+		token(`576p`, fixed("576p")),
+		token(`480p`, fixed("480p")),
+		token(`360p`, fixed("360p")),
+	}
+
+	sourcePatterns = []tokenPattern{
+		token(`blu-?ray|bdrip|brrip|bdremux|bd`, fixed("BluRay")),
+		token(`web-?dl`, fixed("WEB-DL")),
+		token(`web-?rip`, fixed("WEBRip")),
+		token(`web`, fixed("WEB")),
+		token(`hdtv|pdtv`, fixed("HDTV")),
+		token(`dvd-?rip|dvd`, fixed("DVD")),
+		token(`hd-?rip`, fixed("HDRip")),
+		token(`hd-?cam|cam(?:rip)?`, fixed("CAM")),
+		token(`hd-?ts|telesync`, fixed("TS")),
+	}
+
+	codecPatterns = []tokenPattern{
+		token(`x\s?265|h\s?265|hevc`, fixed("H.265")),
+		token(`x\s?264|h\s?264|avc`, fixed("H.264")),
+		token(`av1`, fixed("AV1")),
+		token(`vp9`, fixed("VP9")),
+		token(`xvid`, fixed("XviD")),
+		token(`divx`, fixed("DivX")),
+	}
+
+	audioPatterns = []tokenPattern{
+		token(`truehd(?:\s?atmos)?`, fixed("TrueHD")),
+		token(`atmos`, fixed("Atmos")),
+		token(`dts-?hd(?:\s?ma)?|dts-?x|dts`, fixed("DTS")),
+		token(`ddp?\s?[257]\s[01]|dd\+|e-?ac-?3`, fixed("E-AC3")),
+		token(`dd\s?[257]\s[01]|ac-?3`, fixed("AC3")),
+		token(`aac(?:\s?[257]\s[01])?`, fixed("AAC")),
+		token(`flac`, fixed("FLAC")),
+		token(`opus`, fixed("Opus")),
+		token(`mp3`, fixed("MP3")),
+	}
+
+	languagePatterns = []tokenPattern{
+		token(`english|eng`, fixed("en")),
+		token(`french|fre|fra|vff|vostfr`, fixed("fr")),
+		token(`spanish|spa|castellano|latino`, fixed("es")),
+		token(`german|ger|deu`, fixed("de")),
+		token(`italian|ita`, fixed("it")),
+		token(`russian|rus`, fixed("ru")),
+		token(`japanese|jpn`, fixed("ja")),
+		token(`korean|kor`, fixed("ko")),
+		token(`chinese|chi|chs|cht`, fixed("zh")),
+		token(`hindi|hin`, fixed("hi")),
+		token(`portuguese|por|dublado`, fixed("pt")),
+		token(`multi|dual(?:\s?audio)?`, fixed("multi")),
+	}
+
+	tagPatterns = []tokenPattern{
+		token(`proper`, fixed("PROPER")),
+		token(`repack`, fixed("REPACK")),
+		token(`extended`, fixed("EXTENDED")),
+		token(`unrated`, fixed("UNRATED")),
+		token(`director'?s\s?cut`, fixed("DIRECTORS CUT")),
+		token(`remastered`, fixed("REMASTERED")),
+		token(`remux`, fixed("REMUX")),
+		token(`hdr10\+?|hdr`, fixed("HDR")),
+		token(`dv|dolby\s?vision`, fixed("DV")),
+		token(`10-?bit`, fixed("10bit")),
+		token(`imax`, fixed("IMAX")),
+		token(`complete`, fixed("COMPLETE")),
+	}
+
+	knownExtensions = map[string]bool{
+		".mp4": true, ".mkv": true, ".avi": true, ".mov": true, ".webm": true, ".flv": true,
+		".wmv": true, ".m4v": true, ".3gp": true, ".ts": true,
+		".srt": true, ".vtt": true, ".ass": true, ".ssa": true, ".sub": true, ".torrent": true,
+	}
+)
+
+// ParseEpisode extracts season and episode numbers from a name such as
+// "Show.S01E02", "Show 1x02" or "Show Season 1 Episode 2".
+func ParseEpisode(name string) (season, episode int, ok bool) {
+	n := normalize(name)
+	for _, re := range episodePatterns {
+		if m := re.FindStringSubmatch(n); m != nil {
+			season, _ = strconv.Atoi(m[2])
+			episode, _ = strconv.Atoi(m[3])
+			return season, episode, true
+		}
+	}
+	return 0, 0, false
+}
+
+func normalize(name string) string {
+	return strings.NewReplacer(".", " ", "_", " ").Replace(name)
+}
+
+// Parse turns a torrent or file name into structured metadata. Fields that
+// cannot be recognised are left empty; Title falls back to the cleaned name.
+func Parse(name string) Info {
+	var info Info
+
+	base := filepath.Base(strings.TrimSpace(name))
+	if ext := strings.ToLower(filepath.Ext(base)); knownExtensions[ext] {
+		base = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+
+	// Anime-style releases lead with the group: "[Group] Show - 01 [1080p]"
+	leadingGroup := ""
+	if m := leadingBracket.FindStringSubmatch(base); m != nil {
+		leadingGroup = strings.TrimSpace(m[1])
+		base = strings.TrimSpace(base[len(m[0]):])
+	}
+
+	n := normalize(base)
+	// Position where the title ends: the first recognised token
+	titleEnd := len(n)
+	mark := func(idx int) {
+		if idx >= 0 && idx < titleEnd {
+			titleEnd = idx
+		}
+	}
+
+	for _, re := range episodePatterns {
+		if loc := re.FindStringSubmatchIndex(n); loc != nil {
+			info.Season, _ = strconv.Atoi(n[loc[4]:loc[5]])
+			info.Episode, _ = strconv.Atoi(n[loc[6]:loc[7]])
+			mark(loc[2])
+			break
+		}
+	}
+	if info.Season == 0 && info.Episode == 0 {
+		if loc := seasonPattern.FindStringSubmatchIndex(n); loc != nil {
+			info.Season, _ = strconv.Atoi(n[loc[4]:loc[5]])
+			mark(loc[2])
+		} else if loc := animeEpisode.FindStringSubmatchIndex(n); loc != nil {
+			info.Episode, _ = strconv.Atoi(n[loc[2]:loc[3]])
+			mark(loc[0])
+		}
+	}
+
+	// The last plausible year wins, so "2012 2009 1080p" is the film 2012
+	// from 2009. A year at the very start is part of the title.
+	// Matches share separators, so step past each year rather than using
+	// FindAll, which would skip the second of two adjacent years.
+	for offset := 0; offset < len(n); {
+		loc := yearPattern.FindStringSubmatchIndex(n[offset:])
+		if loc == nil {
+			break
+		}
+		start, end := offset+loc[2], offset+loc[3]
+		offset = end
+		if start == 0 {
+			continue
+		}
+		info.Year, _ = strconv.Atoi(n[start:end])
+		if info.Season == 0 && info.Episode == 0 || start < titleEnd {
+			mark(start)
+		}
+	}
+
+	// With a year or episode to anchor on, the title is everything before
+	// it, so words such as "Web" in "Charlotte's Web" aren't taken for
+	// tags. Only names without an anchor end the title at the first tag.
+	tags, tagMark := n, mark
+	if titleEnd < len(n) {
+		tags, tagMark = n[titleEnd:], func(int) {}
+	}
+	info.Resolution = matchFirst(tags, resolutionPatterns, tagMark)
+	info.Source = matchFirst(tags, sourcePatterns, tagMark)
+	info.Codec = matchFirst(tags, codecPatterns, tagMark)
+	info.Audio = matchFirst(tags, audioPatterns, tagMark)
+	info.Languages = matchAll(tags, languagePatterns, tagMark)
+	info.Tags = matchAll(tags, tagPatterns, tagMark)
+
+	info.Group = leadingGroup
+	if info.Group == "" {
+		info.Group = parseGroup(base)
+	}
+
+	title := n[:titleEnd]
+	if titleEnd == len(n) {
+		// Nothing recognised; drop bracketed junk and any group suffix
+		title = strings.TrimSuffix(title, info.Group)
+	}
+	title = bracketedNoise.ReplaceAllString(title, " ")
+	title = trailingSeparators.ReplaceAllString(title, "")
+	title = whitespace.ReplaceAllString(strings.TrimSpace(title), " ")
+	if title == "" {
+		title = whitespace.ReplaceAllString(strings.TrimSpace(n), " ")
+	}
+	info.Title = title
+
+	return info
+}
+
+func matchFirst(n string, patterns []tokenPattern, mark func(int)) string {
+	best, bestIdx := "", -1
+	for _, p := range patterns {
+		if loc := p.re.FindStringSubmatchIndex(n); loc != nil {
+			if bestIdx == -1 || loc[2] < bestIdx {
+				best, bestIdx = p.value(n[loc[2]:loc[3]]), loc[2]
+			}
+		}
+	}
+	if bestIdx >= 0 {
+		mark(bestIdx)
+	}
+	return best
+}
+
+func matchAll(n string, patterns []tokenPattern, mark func(int)) []string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, p := range patterns {
+		loc := p.re.FindStringSubmatchIndex(n)
+		if loc == nil {
+			continue
+		}
+		// A language word right at the start is part of the title
+		if loc[2] == 0 {
+			continue
+		}
+		mark(loc[2])
+		v := p.value(n[loc[2]:loc[3]])
+		if !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func parseGroup(base string) string {
+	if m := trailingBracket.FindStringSubmatch(base); m != nil {
+		group := strings.TrimSpace(m[1])
+		// "[1080p]" or "[x264]" are not groups
+		if _, _, ok := ParseEpisode(group); !ok && !looksLikeToken(group) {
+			return group
+		}
+	}
+	if loc := trailingGroup.FindStringSubmatchIndex(base); loc != nil {
+		group := strings.TrimSpace(base[loc[2]:loc[3]])
+		// The "DL" of "WEB-DL" or "Rip" of "HD-Rip" is part of the source
+		word := base[:loc[0]]
+		word = word[strings.LastIndexAny(word, " ._[(")+1:]
+		if !looksLikeToken(group) && !looksLikeToken(word+"-"+group) {
+			return group
+		}
+	}
+	return ""
+}
+
+func looksLikeToken(s string) bool {
+	n := " " + normalize(s) + " "
+	for _, list := range [][]tokenPattern{resolutionPatterns, sourcePatterns, codecPatterns, audioPatterns} {
+		for _, p := range list {
+			if loc := p.re.FindStringSubmatchIndex(n); loc != nil && strings.TrimSpace(n[loc[2]:loc[3]]) == strings.TrimSpace(n) {
+				return true
+			}
+		}
+	}
+	return yearPattern.MatchString(n) && len(strings.TrimSpace(n)) == 4
+}
@@ -0,0 +1,63 @@
+package release
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		want Info
+	}{
+		{"The.Bourne.Ultimatum.2007.1080p.BluRay.x264-[YTS.MX]", Info{
+			Title: "The Bourne Ultimatum", Year: 2007, Resolution: "1080p", Source: "BluRay", Codec: "H.264", Group: "YTS.MX",
+		}},
+		{"Show.Name.S01E02.720p.HDTV.x264-LOL.mkv", Info{
+			Title: "Show Name", Season: 1, Episode: 2, Resolution: "720p", Source: "HDTV", Codec: "H.264", Group: "LOL",
+		}},
+		{"[SubsPlease] Frieren - 05 (1080p) [ABCD1234].mkv", Info{
+			Title: "Frieren", Episode: 5, Resolution: "1080p", Group: "SubsPlease",
+		}},
+		{"2012.2009.1080p.BluRay.x264", Info{
+			Title: "2012", Year: 2009, Resolution: "1080p", Source: "BluRay", Codec: "H.264",
+		}},
+		{"Movie Name 1080p BluRay x264-GRP", Info{
+			Title: "Movie Name", Resolution: "1080p", Source: "BluRay", Codec: "H.264", Group: "GRP",
+		}},
+		{"Show.Name.S02E03.1080p.WEB-DL.DDP5.1.H.264-NTb", Info{
+			Title: "Show Name", Season: 2, Episode: 3, Resolution: "1080p", Source: "WEB-DL", Codec: "H.264", Audio: "E-AC3", Group: "NTb",
+		}},
+		{"Movie.Title.2020.MULTi.1080p.WEB.x264-GRP", Info{
+			Title: "Movie Title", Year: 2020, Resolution: "1080p", Source: "WEB", Codec: "H.264", Group: "GRP", Languages: []string{"multi"},
+		}},
+
+		// Words before the year or episode belong to the title even when
+		// they look like tags
+		{"Charlotte's.Web.2006.1080p", Info{
+			Title: "Charlotte's Web", Year: 2006, Resolution: "1080p",
+		}},
+		{"The.Dual.2022.1080p", Info{
+			Title: "The Dual", Year: 2022, Resolution: "1080p",
+		}},
+		{"Cam.2018.1080p.NF.WEBRip.x264", Info{
+			Title: "Cam", Year: 2018, Resolution: "1080p", Source: "WEBRip", Codec: "H.264",
+		}},
+
+		// The end of a dashed source isn't a group
+		{"Show.Name.S01.1080p.WEB-DL", Info{
+			Title: "Show Name", Season: 1, Resolution: "1080p", Source: "WEB-DL",
+		}},
+		{"Movie.2019.720p.HD-Rip", Info{
+			Title: "Movie", Year: 2019, Resolution: "720p", Source: "HDRip",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.name); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q)\n got %+v\nwant %+v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nebyat19/Torrent-Streamer/logger"
+	"github.com/Nebyat19/Torrent-Streamer/probe"
+	"github.com/anacrolix/torrent"
+)
+
+// AudioTrack is an audio stream the player can switch to
+type AudioTrack struct {
+	Index    int    `json:"index"`
+	Language string `json:"language,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Codec    string `json:"codec"`
+	Channels int    `json:"channels,omitempty"`
+	Default  bool   `json:"default"`
+	URL      string `json:"url,omitempty"`
+}
+
+// streamOptions controls how ffmpeg rewrites a file for the browser
+type streamOptions struct {
+	// AudioTrack is the audio stream to keep, counted among audio tracks
+	AudioTrack int
+	// Start is where output begins, in seconds; remuxed output can't be
+	// range-requested, so the player seeks by restarting here
+	Start float64
+	// Profile re-encodes the video; nil copies it untouched
+	Profile *transcodeProfile
+	// BurnSubtitle is an ASS file rendered into the video; needs a Profile
+	BurnSubtitle string
+}
+
+var (
+	errNoFFmpeg       = errors.New("ffmpeg is not installed")
+	errTranscodesBusy = errors.New("too many transcodes in progress")
+)
+
+var ffmpegBinary = sync.OnceValue(func() string {
+	if path := os.Getenv("FFMPEG_PATH"); path != "" {
+		return path
+	}
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return ""
+	}
+	return path
+})
+
+func ffmpegAvailable() bool {
+	return ffmpegBinary() != ""
+}
+
+// ISO 639-1 codes and the 639-2 codes containers use for the same language
+var languageAliases = map[string][]string{
+	"en": {"eng"}, "fr": {"fre", "fra"}, "es": {"spa"}, "de": {"ger", "deu"},
+	"it": {"ita"}, "ja": {"jpn"}, "ko": {"kor"}, "zh": {"chi", "zho"},
+	"ru": {"rus"}, "pt": {"por"}, "hi": {"hin"}, "nl": {"dut", "nld"},
+	"ar": {"ara"}, "pl": {"pol"}, "tr": {"tur"}, "sv": {"swe"},
+}
+
+// languageMatches compares language tags loosely: "en", "eng" and "en-US"
+// all match each other.
+func languageMatches(a, b string) bool {
+	a, _, _ = strings.Cut(strings.ToLower(a), "-")
+	b, _, _ = strings.Cut(strings.ToLower(b), "-")
+	if a == "" || b == "" {
+		return false
+	}
+	if a == b {
+		return true
+	}
+	for short, long := range languageAliases {
+		group := append([]string{short}, long...)
+		if containsString(group, a) && containsString(group, b) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// audioTracks lists the audio streams of a probed file for StreamStatus
+func audioTracks(info *probe.Info, videoURL string) []AudioTrack {
+	var tracks []AudioTrack
+	for _, t := range info.TracksOf(probe.Audio) {
+		track := AudioTrack{
+			Index:    t.Index,
+			Language: t.Language,
+			Name:     t.Name,
+			Codec:    t.Codec,
+			Channels: t.Channels,
+			Default:  t.Default,
+		}
+		if ffmpegAvailable() {
+			track.URL = fmt.Sprintf("%s&audio=%d", videoURL, t.Index)
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+// resolveAudioTrack turns an audio= parameter, either an index among audio
+// tracks or a language, into a track index.
+func resolveAudioTrack(info *probe.Info, param string) (int, error) {
+	if n, err := strconv.Atoi(param); err == nil {
+		if n < 0 {
+			return 0, fmt.Errorf("invalid audio track %d", n)
+		}
+		// Without probe results let ffmpeg decide whether the track exists
+		if info != nil && n >= len(info.TracksOf(probe.Audio)) {
+			return 0, fmt.Errorf("no audio track %d", n)
+		}
+		return n, nil
+	}
+
+	if info == nil {
+		return 0, errors.New("audio tracks are not known yet")
+	}
+	for _, t := range info.TracksOf(probe.Audio) {
+		if languageMatches(t.Language, param) {
+			return t.Index, nil
+		}
+	}
+	return 0, fmt.Errorf("no %s audio track", param)
+}
+
+// serveFileLocally exposes a torrent file on a loopback port for ffmpeg.
+// Unlike a pipe, HTTP lets ffmpeg seek, which MP4 files with their index
+// at the end need. The listener closes when ctx is done.
+func serveFileLocally(ctx context.Context, f *torrent.File) (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reader := f.NewReader()
+			defer reader.Close()
+			reader.SetResponsive()
+			reader.SetReadahead(4 << 20)
+			http.ServeContent(w, r, "", time.Time{}, contextReader{ctx: r.Context(), Reader: reader})
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go srv.Serve(ln)
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	return "http://" + ln.Addr().String() + "/", nil
+}
+
+// ffmpegArgs builds the ffmpeg command line. Without a profile video is
+// copied untouched and the chosen audio track is copied when browsers can
+// play it and converted to stereo AAC otherwise. Output is fragmented MP4
+// so it can be streamed.
+func ffmpegArgs(input string, opts streamOptions, audioCodec string) []string {
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin"}
+	if opts.Start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(opts.Start, 'f', 3, 64))
+	}
+	args = append(args,
+		"-i", input,
+		"-map", "0:v:0",
+		// Trailing ? keeps files without audio working
+		"-map", fmt.Sprintf("0:a:%d?", opts.AudioTrack),
+	)
+
+	switch {
+	case opts.Profile != nil:
+		var filters []string
+		if opts.BurnSubtitle != "" {
+			filters = append(filters, subtitlesFilter(opts.BurnSubtitle))
+		}
+		args = append(args, opts.Profile.videoArgs(filters...)...)
+		args = append(args, opts.Profile.audioArgs()...)
+	case browserAudioCodecs[audioCodec] && audioCodec != "flac":
+		args = append(args, "-c:v", "copy", "-c:a", "copy")
+	default:
+		args = append(args, "-c:v", "copy", "-c:a", "aac", "-b:a", "192k", "-ac", "2")
+	}
+
+	return append(args,
+		"-sn", "-dn",
+		"-f", "mp4",
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"pipe:1",
+	)
+}
+
+// streamFFmpeg pipes the session's file through ffmpeg to the client. The
+// process is killed as soon as the client goes away.
+func streamFFmpeg(w http.ResponseWriter, r *http.Request, session *UserSession, opts streamOptions) error {
+	if !ffmpegAvailable() {
+		return errNoFFmpeg
+	}
+
+	// Encoding is CPU-bound, so refuse rather than queue when all slots are busy;
+	// remuxing only copies and is not limited
+	if opts.Profile != nil {
+		select {
+		case transcodeSlots <- struct{}{}:
+			defer func() { <-transcodeSlots }()
+		default:
+			transcodesRejected.Inc()
+			return errTranscodesBusy
+		}
+	}
+
+	log := logger.FromContext(r.Context())
+	f := session.File
+
+	audioCodec := ""
+	if session.Media != nil {
+		if tracks := session.Media.TracksOf(probe.Audio); opts.AudioTrack < len(tracks) {
+			audioCodec = tracks[opts.AudioTrack].Codec
+		}
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	input, err := serveFileLocally(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegBinary(), ffmpegArgs(input, opts, audioCodec)...)
+	cmd.Stderr = &stderr
+	// Don't let a wedged ffmpeg hold the handler after it has been killed
+	cmd.WaitDelay = 5 * time.Second
+
+	rec := newResponseRecorder(w)
+	defer func() { videoBytesServed.Add(float64(rec.bytes)) }()
+	cmd.Stdout = flushWriter{rec}
+
+	// Output streams for as long as the video plays
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	mode := "Remuxing"
+	if opts.Profile != nil {
+		mode = "Transcoding to " + opts.Profile.Name
+	}
+	if opts.BurnSubtitle != "" {
+		mode += " with burned-in subtitles"
+	}
+	log.Info("%s %s with audio track %d from %.0fs", mode, f.Path(), opts.AudioTrack, opts.Start)
+	start := time.Now()
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		// Client disconnected or seeked; not an error
+		log.Debug("%s of %s stopped after %s", mode, f.Path(), time.Since(start).Round(time.Second))
+		return nil
+	}
+	if err != nil {
+		log.Error("ffmpeg failed for %s: %v: %s", f.Path(), err, strings.TrimSpace(stderr.String()))
+		if rec.bytes == 0 {
+			http.Error(w, "Remux failed", http.StatusInternalServerError)
+		}
+	}
+	return nil
+}
+
+// flushWriter pushes every chunk ffmpeg writes straight to the client
+type flushWriter struct {
+	w *responseRecorder
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.w.Flush()
+	return n, err
+}
+
+// remuxRequested reports whether a /video request needs ffmpeg
+func remuxRequested(r *http.Request) bool {
+	query := r.URL.Query()
+	return query.Get("audio") != "" || query.Get("profile") != "" || query.Get("burn") != ""
+}
+
+func remuxHandler(w http.ResponseWriter, r *http.Request, session *UserSession) {
+	query := r.URL.Query()
+	var opts streamOptions
+
+	if param := query.Get("audio"); param != "" {
+		track, err := resolveAudioTrack(session.Media, param)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.AudioTrack = track
+	} else if session.Media != nil {
+		if t := defaultAudioTrack(session.Media); t != nil {
+			opts.AudioTrack = t.Index
+		}
+	}
+
+	if name := query.Get("profile"); name != "" {
+		profile, ok := findTranscodeProfile(name)
+		if !ok {
+			http.Error(w, "Unknown profile "+name, http.StatusBadRequest)
+			return
+		}
+		opts.Profile = profile
+	}
+
+	start, err := strconv.ParseFloat(query.Get("start"), 64)
+	if err == nil && start > 0 {
+		opts.Start = start
+	}
+
+	// Burning in subtitles means re-encoding, so it implies a profile
+	if param := query.Get("burn"); param != "" {
+		sub, ok := findSubtitle(session, param)
+		if !ok {
+			http.Error(w, "Unknown subtitle "+param, http.StatusBadRequest)
+			return
+		}
+		path, err := prepareBurnSubtitle(session, sub, opts.Start)
+		if err != nil {
+			logger.FromContext(r.Context()).Error("Error preparing subtitle for burn-in: %v", err)
+			http.Error(w, "Could not read subtitle", http.StatusUnprocessableEntity)
+			return
+		}
+		if path != "" {
+			defer os.Remove(path)
+			opts.BurnSubtitle = path
+		}
+		if opts.Profile == nil {
+			opts.Profile, _ = findTranscodeProfile(burnProfile)
+		}
+	}
+
+	if err := streamFFmpeg(w, r, session, opts); err != nil {
+		switch {
+		case errors.Is(err, errNoFFmpeg):
+			http.Error(w, "Audio track selection, transcoding and subtitle burn-in require ffmpeg on the server", http.StatusNotImplemented)
+		case errors.Is(err, errTranscodesBusy):
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "Too many transcodes in progress, try again later", http.StatusServiceUnavailable)
+		default:
+			logger.FromContext(r.Context()).Error("Remux error: %v", err)
+			http.Error(w, "Remux failed", http.StatusInternalServerError)
+		}
+	}
+}
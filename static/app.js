@@ -1,11 +1,22 @@
 class TorrentStreamer {
     constructor() {
-        this.apiBase = "/api"
+        this.apiBase = "/api/v1"
         this.ytsApiBase = "https://yts.lt/api/v2"
         this.currentSubtitles = []
         this.progressInterval = null
         this.statusInterval = null
         this.currentVideoUrl = null
+        // Selected audio track and transcode profile; null plays the file as-is
+        this.audioTrack = null
+        this.profile = null
+        // Index into the status subtitles of one burned into the video
+        this.burn = null
+        this.audioTracksKey = ""
+        this.profilesKey = ""
+        // Remuxed streams start at the seek point, so the player's clock is offset
+        this.timeOffset = 0
+        this.mediaDuration = 0
+        this.lastPositionReport = 0
         this.startTime = Date.now()
         this.currentPage = 1
         this.currentMovies = []
@@ -52,6 +63,14 @@ class TorrentStreamer {
         const video = document.getElementById("videoPlayer")
         video.addEventListener("timeupdate", () => {
             this.updateStreamTime()
+            if (Date.now() - this.lastPositionReport > 10000) {
+                this.reportPosition()
+            }
+        })
+        video.addEventListener("pause", () => this.reportPosition())
+        video.addEventListener("ended", () => {
+            this.reportPosition()
+            this.playNext()
         })
 
         // Search inputs
@@ -683,16 +702,29 @@ class TorrentStreamer {
 
             if (result.success) {
                 const data = result.data
+                this.currentMagnet = data.magnet
 
                 if (this.shouldUpdateUI(data)) {
                     this.updateUI(data)
                 }
+                this.showPlaybackWarnings(data)
+                this.updateAudioTracks(data.audioTracks || [])
+                this.updateProfiles(data.profiles || [])
             }
         } catch (error) {
             console.error("Status update error:", error)
         }
     }
 
+    // Probing finishes after the stream starts, so warnings arrive on a later poll
+    showPlaybackWarnings(data) {
+        if (!data.videoUrl || !data.warnings?.length || this.warnedVideoUrl === data.videoUrl) {
+            return
+        }
+        this.warnedVideoUrl = data.videoUrl
+        this.showNotification(`⚠️ ${data.warnings[0]}`, "error")
+    }
+
     shouldUpdateUI(newData) {
         const currentStatus = document.getElementById("statusText").textContent
         if (currentStatus != newData.status) {
@@ -700,6 +732,11 @@ class TorrentStreamer {
             return true
         }
 
+        // Playlist moved to another episode
+        if (newData.videoUrl && this.currentVideoUrl && newData.videoUrl !== this.currentVideoUrl) {
+            return true
+        }
+
         //   if (newData.videoUrl && !this.currentVideoUrl) {
         //     this.currentVideoUrl = newData.videoUrl
         //     return true
@@ -723,8 +760,11 @@ class TorrentStreamer {
         const statusText = document.getElementById("statusText")
         const statusDot = document.querySelector(".status-dot")
 
-        // Update status text
-        statusText.textContent = data.status
+        // Update status text, preferring the parsed title over the raw release name
+        statusText.textContent = data.title && data.status.startsWith("Streaming") ? `Streaming: ${data.title}` : data.status
+
+        // Metadata can take minutes to arrive, so the wait can be cancelled
+        document.getElementById("stopStreamBtn").style.display = data.metadata ? "" : "none"
 
         // Update status indicator color
         if (statusDot) {
@@ -750,16 +790,26 @@ class TorrentStreamer {
 
             document.getElementById("fileType").textContent = data.fileType?.toUpperCase() || "FILE"
             document.getElementById("fileSize").textContent = this.formatFileSize(data.fileSize)
-            document.getElementById("quality").textContent = this.getQualityFromSize(data.fileSize)
+            document.getElementById("quality").textContent = data.release?.resolution || this.getQualityFromSize(data.fileSize)
 
             const video = document.getElementById("videoPlayer")
             const currentVideoUrl = video.currentSrc || video.src || ""
-            const newVideoUrl = data.videoUrl
+            if (data.videoUrl !== this.currentVideoUrl) {
+                this.audioTrack = null
+                this.burn = null
+                this.timeOffset = 0
+            }
+            this.mediaDuration = data.media?.duration || 0
+            if (data.poster) {
+                video.poster = data.poster
+            }
+            const newVideoUrl = this.isRemuxed() ? this.streamUrl(data.videoUrl, this.playbackPosition()) : data.videoUrl
 
             //if (currentVideoUrl !== newVideoUrl && !currentVideoUrl.includes(newVideoUrl)) {
             console.log("🎥 Updating video source:", { from: currentVideoUrl, to: newVideoUrl })
 
             video.src = newVideoUrl
+            this.currentVideoUrl = data.videoUrl
 
             const handleLoadStart = () => {
                 console.log("📺 Video loading started")
@@ -784,12 +834,181 @@ class TorrentStreamer {
             video.addEventListener("error", handleError)
             //  }
 
+            if (data.resumePosition > 0 && !this.isRemuxed()) {
+                const handleResume = () => {
+                    video.currentTime = data.resumePosition
+                    this.showNotification(`Resuming at ${this.formatTime(data.resumePosition)}`, "success")
+                    video.removeEventListener("loadedmetadata", handleResume)
+                }
+                video.addEventListener("loadedmetadata", handleResume)
+            }
+
             this.updateSubtitles(data.subtitles || [])
         } else {
             mediaSection.style.display = "none"
         }
     }
 
+    async playNext() {
+        try {
+            const response = await fetch(`${this.apiBase}/playlist`, {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ action: "next" }),
+            })
+            const result = await response.json()
+            if (result.success) {
+                await this.updateStatus()
+                document.getElementById("videoPlayer").play().catch(() => {})
+            }
+        } catch (error) {
+            console.error("Playlist error:", error)
+        }
+    }
+
+    async reportPosition() {
+        const video = document.getElementById("videoPlayer")
+        if (!video || !video.src || !video.currentTime) {
+            return
+        }
+        this.lastPositionReport = Date.now()
+
+        try {
+            await fetch(`${this.apiBase}/position`, {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({
+                    position: this.playbackPosition(),
+                    duration: this.playbackDuration(),
+                }),
+            })
+        } catch (error) {
+            console.error("Position report error:", error)
+        }
+    }
+
+    playbackPosition() {
+        const video = document.getElementById("videoPlayer")
+        return this.timeOffset + (video?.currentTime || 0)
+    }
+
+    playbackDuration() {
+        const video = document.getElementById("videoPlayer")
+        if (!this.isRemuxed() && Number.isFinite(video?.duration)) {
+            return video.duration
+        }
+        return this.mediaDuration
+    }
+
+    // Track selection and transcoding go through the server's ffmpeg
+    isRemuxed() {
+        return this.audioTrack !== null || this.profile !== null || this.burn !== null
+    }
+
+    streamUrl(videoUrl, start) {
+        const startAt = Math.floor(start)
+        this.timeOffset = startAt
+        let url = videoUrl
+        if (this.audioTrack !== null) {
+            url += `&audio=${this.audioTrack}`
+        }
+        if (this.profile !== null) {
+            url += `&profile=${this.profile}`
+        }
+        if (this.burn !== null) {
+            url += `&burn=${this.burn}`
+        }
+        return `${url}&start=${startAt}`
+    }
+
+    // Restart the stream at the current position with new ffmpeg options
+    restartStream() {
+        const video = document.getElementById("videoPlayer")
+        const position = this.playbackPosition()
+        if (this.isRemuxed()) {
+            video.src = this.streamUrl(this.currentVideoUrl, position)
+        } else {
+            this.timeOffset = 0
+            video.src = this.currentVideoUrl
+            video.addEventListener("loadedmetadata", () => { video.currentTime = position }, { once: true })
+        }
+        video.play().catch(() => {})
+    }
+
+    updateAudioTracks(tracks) {
+        const key = JSON.stringify(tracks)
+        if (key === this.audioTracksKey) {
+            return
+        }
+        this.audioTracksKey = key
+
+        const section = document.getElementById("audioSection")
+        const controls = document.getElementById("audioControls")
+        controls.innerHTML = ""
+
+        // Switching needs the server's ffmpeg, which is when tracks carry a URL
+        if (tracks.length < 2 || !tracks[0].url) {
+            section.style.display = "none"
+            return
+        }
+        section.style.display = "block"
+
+        tracks.forEach((track) => {
+            const button = document.createElement("button")
+            const selected = this.audioTrack === null ? track.default : this.audioTrack === track.index
+            button.className = selected ? "subtitle-btn active" : "subtitle-btn"
+            button.onclick = () => this.selectAudioTrack(track, button)
+            const label = track.name || (track.language ? track.language.toUpperCase() : `Track ${track.index + 1}`)
+            button.textContent = `🔊 ${label} (${track.codec.toUpperCase()}${track.channels ? ` ${track.channels}ch` : ""})`
+            controls.appendChild(button)
+        })
+    }
+
+    selectAudioTrack(track, button) {
+        this.audioTrack = track.index
+        this.restartStream()
+
+        document.querySelectorAll("#audioControls .subtitle-btn").forEach((btn) => btn.classList.remove("active"))
+        button.classList.add("active")
+        this.showNotification(`Audio changed to ${track.name || track.language || `track ${track.index + 1}`}`, "success")
+    }
+
+    updateProfiles(profiles) {
+        const key = JSON.stringify(profiles)
+        if (key === this.profilesKey) {
+            return
+        }
+        this.profilesKey = key
+
+        const section = document.getElementById("qualitySection")
+        const controls = document.getElementById("qualityControls")
+        controls.innerHTML = ""
+        // Profiles are only offered when the server has ffmpeg, which burn-in needs too
+        document.getElementById("burnToggle").style.display = profiles.length ? "flex" : "none"
+        if (profiles.length === 0) {
+            section.style.display = "none"
+            return
+        }
+        section.style.display = "block"
+
+        ;[null, ...profiles].forEach((profile) => {
+            const button = document.createElement("button")
+            button.className = this.profile === profile ? "subtitle-btn active" : "subtitle-btn"
+            button.textContent = profile === null ? "Original" : profile
+            button.onclick = () => this.selectProfile(profile, button)
+            controls.appendChild(button)
+        })
+    }
+
+    selectProfile(profile, button) {
+        this.profile = profile
+        this.restartStream()
+
+        document.querySelectorAll("#qualityControls .subtitle-btn").forEach((btn) => btn.classList.remove("active"))
+        button.classList.add("active")
+        this.showNotification(profile === null ? "Playing original quality" : `Transcoding to ${profile}`, "success")
+    }
+
     updateSubtitles(subtitles) {
         this.currentSubtitles = subtitles
         const subtitleControls = document.getElementById("subtitleControls")
@@ -843,9 +1062,10 @@ class TorrentStreamer {
 
     updateStreamTime() {
         const video = document.getElementById("videoPlayer")
-        if (video && video.duration) {
-            const current = this.formatTime(video.currentTime)
-            const total = this.formatTime(video.duration)
+        const duration = this.playbackDuration()
+        if (video && duration) {
+            const current = this.formatTime(this.playbackPosition())
+            const total = this.formatTime(duration)
             document.getElementById("streamTime").textContent = `${current} / ${total}`
         }
     }
@@ -915,7 +1135,14 @@ class TorrentStreamer {
         const video = document.getElementById("videoPlayer")
         const track = document.getElementById("subtitleTrack")
 
-        if (url === "none") {
+        const burnIn = document.getElementById("burnSubtitles").checked
+        const burnIndex = url === "none" ? -1 : this.currentSubtitles.findIndex((sub) => sub.path === url)
+        if (burnIn || this.burn !== null) {
+            this.burn = burnIn && burnIndex >= 0 ? burnIndex : null
+            this.restartStream()
+        }
+
+        if (url === "none" || this.burn !== null) {
             track.src = ""
             track.label = "None"
             track.srclang = "none"
@@ -928,7 +1155,7 @@ class TorrentStreamer {
         }
 
         // Update active button
-        document.querySelectorAll(".subtitle-btn").forEach((btn) => {
+        document.querySelectorAll("#subtitleControls .subtitle-btn").forEach((btn) => {
             btn.classList.remove("active")
         })
         event.target.classList.add("active")
@@ -968,6 +1195,51 @@ class TorrentStreamer {
         }
     }
 
+    async stopStream() {
+        try {
+            const response = await fetch(`${this.apiBase}/stream`, { method: "DELETE" })
+            const result = await response.json()
+
+            if (result.success) {
+                this.showNotification("Stream cancelled", "success")
+                this.updateStatus()
+            } else {
+                this.showNotification(result.error || "Could not cancel", "error")
+            }
+        } catch (error) {
+            console.error("Stop stream error:", error)
+            this.showNotification("Could not cancel", "error")
+        }
+    }
+
+    async downloadForLater() {
+        if (!this.currentMagnet) {
+            this.showNotification("Nothing is streaming", "error")
+            return
+        }
+
+        try {
+            const response = await fetch(`${this.apiBase}/downloads`, {
+                method: "POST",
+                headers: {
+                    "Content-Type": "application/json",
+                },
+                body: JSON.stringify({ magnet: this.currentMagnet }),
+            })
+
+            const result = await response.json()
+
+            if (result.success) {
+                this.showNotification("⬇️ Downloading into the library", "success")
+            } else {
+                this.showNotification(result.error || "Download failed", "error")
+            }
+        } catch (error) {
+            console.error("Download error:", error)
+            this.showNotification("Download failed", "error")
+        }
+    }
+
     async pasteMagnetLink() {
         try {
             const text = await navigator.clipboard.readText()
@@ -1135,6 +1407,14 @@ function uploadSubtitle() {
     window.streamer.uploadSubtitle()
 }
 
+function stopStream() {
+    window.streamer.stopStream()
+}
+
+function downloadForLater() {
+    window.streamer.downloadForLater()
+}
+
 function resetSession() {
     window.streamer.resetSession()
 }
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nebyat19/Torrent-Streamer/logger"
+	"github.com/Nebyat19/Torrent-Streamer/probe"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	thumbnailDir = "cache/thumbnails"
+
+	thumbnailWidth    = 160
+	posterWidth       = 640
+	maxThumbnails     = 100
+	minThumbnailEvery = 10 * time.Second
+	spriteColumns     = 10
+
+	// Frames are only extracted once this much data past the frame's
+	// estimated offset is on disk
+	thumbnailWindow = 2 << 20
+
+	thumbnailPassInterval = 30 * time.Second
+	thumbnailFrameTimeout = 30 * time.Second
+	maxThumbnailJobs      = 2
+)
+
+// thumbnailManifest records what has been generated for one file, so the
+// sprite and its WebVTT track survive restarts.
+type thumbnailManifest struct {
+	Duration float64 `json:"duration"`
+	Interval float64 `json:"interval"`
+	Count    int     `json:"count"`
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Columns  int     `json:"columns"`
+	Done     []bool  `json:"done"`
+	Poster   bool    `json:"poster"`
+}
+
+func (m *thumbnailManifest) doneCount() int {
+	n := 0
+	for _, done := range m.Done {
+		if done {
+			n++
+		}
+	}
+	return n
+}
+
+var (
+	thumbnailJobs     = make(map[string]bool)
+	thumbnailJobsLock sync.Mutex
+	thumbnailSlots    = make(chan struct{}, maxThumbnailJobs)
+
+	infoHashPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+)
+
+// thumbnailPath returns the cache directory for one file of a torrent
+func thumbnailPath(infoHash, filePath string) string {
+	sum := sha1.Sum([]byte(filePath))
+	return filepath.Join(thumbnailDir, infoHash, hex.EncodeToString(sum[:8]))
+}
+
+func loadThumbnailManifest(dir string) (*thumbnailManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	var m thumbnailManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if m.Interval <= 0 || m.Columns <= 0 || len(m.Done) != m.Count {
+		return nil, errors.New("invalid thumbnail manifest")
+	}
+	return &m, nil
+}
+
+func saveThumbnailManifest(dir string, m *thumbnailManifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "manifest.json.tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, "manifest.json"))
+}
+
+func newThumbnailManifest(info *probe.Info) *thumbnailManifest {
+	interval := math.Max(minThumbnailEvery.Seconds(), info.Duration/maxThumbnails)
+	// A corrupt duration mustn't size the frame list
+	count := 0
+	if n := math.Ceil(info.Duration / interval); n > 0 && n <= maxThumbnails {
+		count = int(n)
+	}
+
+	height := thumbnailWidth * 9 / 16
+	if v := info.VideoTrack(); v != nil && v.Width > 0 && v.Height > 0 {
+		height = thumbnailWidth * v.Height / v.Width
+	}
+	height += height % 2
+
+	return &thumbnailManifest{
+		Duration: info.Duration,
+		Interval: interval,
+		Count:    count,
+		Width:    thumbnailWidth,
+		Height:   height,
+		Columns:  spriteColumns,
+		Done:     make([]bool, count),
+	}
+}
+
+// startThumbnails generates a poster and sprite sheet for a file in the
+// background, once per file no matter how many sessions select it.
+func startThumbnails(f *torrent.File, info *probe.Info) {
+	if !ffmpegAvailable() || info == nil || info.Duration <= 0 || math.IsNaN(info.Duration) || math.IsInf(info.Duration, 0) || info.VideoTrack() == nil {
+		return
+	}
+
+	key := f.Torrent().InfoHash().HexString() + "/" + f.Path()
+	thumbnailJobsLock.Lock()
+	if thumbnailJobs[key] {
+		thumbnailJobsLock.Unlock()
+		return
+	}
+	thumbnailJobs[key] = true
+	thumbnailJobsLock.Unlock()
+
+	go func() {
+		defer recoverFromPanic("thumbnails")
+		defer func() {
+			thumbnailJobsLock.Lock()
+			delete(thumbnailJobs, key)
+			thumbnailJobsLock.Unlock()
+		}()
+		runThumbnailJob(f, info)
+	}()
+}
+
+func runThumbnailJob(f *torrent.File, info *probe.Info) {
+	log := mediaLog.With(logger.InfoHash(f.Torrent().InfoHash().HexString()))
+	dir := thumbnailPath(f.Torrent().InfoHash().HexString(), f.Path())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Error("Error creating thumbnail directory: %v", err)
+		return
+	}
+
+	m, err := loadThumbnailManifest(dir)
+	if err != nil || m.Count == 0 {
+		m = newThumbnailManifest(info)
+	}
+	if m.Poster && m.doneCount() == m.Count {
+		return
+	}
+
+	ticker := time.NewTicker(thumbnailPassInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case thumbnailSlots <- struct{}{}:
+		case <-appContext.Done():
+			return
+		}
+		added := thumbnailPass(f, dir, m)
+		<-thumbnailSlots
+
+		if added > 0 {
+			if err := writeSprite(dir, m); err != nil {
+				log.Error("Error writing thumbnail sprite: %v", err)
+			}
+			if err := saveThumbnailManifest(dir, m); err != nil {
+				log.Error("Error saving thumbnail manifest: %v", err)
+			}
+			log.Debug("Generated %d thumbnails for %s (%d/%d)", added, f.Path(), m.doneCount(), m.Count)
+		}
+
+		if m.Poster && m.doneCount() == m.Count {
+			log.Info("Thumbnails complete for %s", f.Path())
+			return
+		}
+		if !fileSelected(f) {
+			log.Debug("Stopping thumbnails for %s, no longer selected", f.Path())
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-appContext.Done():
+			return
+		}
+	}
+}
+
+// thumbnailPass extracts every frame whose data is already downloaded and
+// returns how many were added.
+func thumbnailPass(f *torrent.File, dir string, m *thumbnailManifest) int {
+	ctx, cancel := context.WithCancel(appContext)
+	defer cancel()
+
+	input, err := serveFileLocally(ctx, f)
+	if err != nil {
+		mediaLog.Error("Error serving file to ffmpeg: %v", err)
+		return 0
+	}
+
+	added := 0
+	for i := 0; i < m.Count; i++ {
+		if m.Done[i] {
+			continue
+		}
+		at := (float64(i) + 0.5) * m.Interval
+		if !timeDownloaded(f, at, m.Duration) {
+			continue
+		}
+		out := filepath.Join(dir, fmt.Sprintf("frame-%04d.jpg", i))
+		if err := extractFrame(ctx, input, at, m.Width, m.Height, out); err != nil {
+			mediaLog.Debug("Could not extract frame at %.0fs of %s: %v", at, f.Path(), err)
+			continue
+		}
+		m.Done[i] = true
+		added++
+	}
+
+	// The poster comes from a tenth of the way in, past most intros' black frames
+	if !m.Poster {
+		at := m.Duration / 10
+		if timeDownloaded(f, at, m.Duration) {
+			height := posterWidth * m.Height / m.Width
+			height += height % 2
+			if err := extractFrame(ctx, input, at, posterWidth, height, filepath.Join(dir, "poster.jpg")); err == nil {
+				m.Poster = true
+				added++
+			}
+		}
+	}
+	return added
+}
+
+// timeDownloaded estimates where a timestamp lies in the file, assuming a
+// roughly constant bitrate, and reports whether that region is on disk.
+func timeDownloaded(f *torrent.File, at, duration float64) bool {
+	start := int64(at / duration * float64(f.Length()))
+	return fileRangeComplete(f, start, start+thumbnailWindow)
+}
+
+func fileRangeComplete(f *torrent.File, start, end int64) bool {
+	if end > f.Length() {
+		end = f.Length()
+	}
+	var offset int64
+	for _, ps := range f.State() {
+		if offset+ps.Bytes > start && offset < end && !ps.Complete {
+			return false
+		}
+		offset += ps.Bytes
+		if offset >= end {
+			break
+		}
+	}
+	return true
+}
+
+func extractFrame(ctx context.Context, input string, at float64, width, height int, out string) error {
+	ctx, cancel := context.WithTimeout(ctx, thumbnailFrameTimeout)
+	defer cancel()
+
+	scale := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2",
+		width, height, width, height)
+	tmp := out + ".tmp.jpg"
+	cmd := exec.CommandContext(ctx, ffmpegBinary(),
+		"-hide_banner", "-loglevel", "error", "-nostdin",
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64),
+		"-i", input,
+		"-frames:v", "1", "-an", "-sn",
+		"-vf", scale,
+		"-q:v", "5",
+		"-y", tmp,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return os.Rename(tmp, out)
+}
+
+// writeSprite tiles the extracted frames into one image; frames not yet
+// extracted stay black.
+func writeSprite(dir string, m *thumbnailManifest) error {
+	rows := (m.Count + m.Columns - 1) / m.Columns
+	sprite := image.NewRGBA(image.Rect(0, 0, m.Columns*m.Width, rows*m.Height))
+
+	for i, done := range m.Done {
+		if !done {
+			continue
+		}
+		frame, err := readJPEG(filepath.Join(dir, fmt.Sprintf("frame-%04d.jpg", i)))
+		if err != nil {
+			m.Done[i] = false
+			continue
+		}
+		x, y := (i%m.Columns)*m.Width, (i/m.Columns)*m.Height
+		draw.Draw(sprite, image.Rect(x, y, x+m.Width, y+m.Height), frame, frame.Bounds().Min, draw.Src)
+	}
+
+	tmp := filepath.Join(dir, "sprite.jpg.tmp")
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(out, sprite, &jpeg.Options{Quality: 75}); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, "sprite.jpg"))
+}
+
+func readJPEG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return jpeg.Decode(f)
+}
+
+// fileSelected reports whether any session is still playing a file
+func fileSelected(f *torrent.File) bool {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+	for _, s := range sessions {
+		if s.File == f {
+			return true
+		}
+	}
+	return false
+}
+
+// thumbnailTarget picks the file a thumbnail request is about: an explicit
+// infoHash and file, as history links use, or the session's current file.
+// An explicit file must be one the caller holds or has in their history,
+// unless the request is an admin's.
+func thumbnailTarget(w http.ResponseWriter, r *http.Request) (infoHash, filePath string, ok bool) {
+	query := r.URL.Query()
+	if infoHash = strings.ToLower(query.Get("infoHash")); infoHash != "" {
+		if !infoHashPattern.MatchString(infoHash) {
+			http.Error(w, "invalid info hash", http.StatusBadRequest)
+			return "", "", false
+		}
+		filePath = query.Get("file")
+		if !isAdminRequest(r) && !thumbnailHeld(w, r, infoHash, filePath) {
+			http.Error(w, "Thumbnail not found", http.StatusNotFound)
+			return "", "", false
+		}
+		return infoHash, filePath, true
+	}
+
+	session := getSession(w, r)
+	if session.Torrent == nil || session.File == nil {
+		http.Error(w, "no file selected", http.StatusBadRequest)
+		return "", "", false
+	}
+	return session.Torrent.InfoHash().HexString(), session.File.Path(), true
+}
+
+// thumbnailHeld reports whether the caller's session or downloads hold the
+// torrent, or the file is in their watch history
+func thumbnailHeld(w http.ResponseWriter, r *http.Request, infoHash, filePath string) bool {
+	var ih metainfo.Hash
+	if err := ih.FromHexString(infoHash); err == nil {
+		if t, ok := client.Torrent(ih); ok && queue.heldBy(t, callerHolders(w, r)) {
+			return true
+		}
+	}
+	return history.has(getUserID(w, r), infoHash, filePath)
+}
+
+// thumbnailURL builds a link to a file's thumbnails that works without a session
+func thumbnailURL(infoHash, filePath, extra string) string {
+	u := apiPrefix + "/thumbnail?infoHash=" + infoHash + "&file=" + url.QueryEscape(filePath)
+	if extra != "" {
+		u += "&" + extra
+	}
+	return u
+}
+
+// apiThumbnailHandler serves the poster frame, or with ?sprite the sprite
+// sheet, or with ?t=<seconds> the single frame nearest that time.
+func apiThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	infoHash, filePath, ok := thumbnailTarget(w, r)
+	if !ok {
+		return
+	}
+	dir := thumbnailPath(infoHash, filePath)
+
+	name := "poster.jpg"
+	query := r.URL.Query()
+	switch {
+	case query.Has("sprite"):
+		name = "sprite.jpg"
+	case query.Get("t") != "":
+		m, err := loadThumbnailManifest(dir)
+		t, perr := strconv.ParseFloat(query.Get("t"), 64)
+		if err != nil || perr != nil || t < 0 || math.IsNaN(t) || math.IsInf(t, 0) {
+			http.Error(w, "Thumbnail not found", http.StatusNotFound)
+			return
+		}
+		i := int(t / m.Interval)
+		if i < 0 || i >= len(m.Done) || !m.Done[i] {
+			http.Error(w, "Thumbnail not ready", http.StatusNotFound)
+			return
+		}
+		name = fmt.Sprintf("frame-%04d.jpg", i)
+	}
+
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err != nil {
+		http.Error(w, "Thumbnail not ready", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, path)
+}
+
+// apiThumbnailTrackHandler serves a WebVTT thumbnails track pointing into
+// the sprite sheet, the format seek-bar preview plugins expect.
+func apiThumbnailTrackHandler(w http.ResponseWriter, r *http.Request) {
+	infoHash, filePath, ok := thumbnailTarget(w, r)
+	if !ok {
+		return
+	}
+
+	m, err := loadThumbnailManifest(thumbnailPath(infoHash, filePath))
+	if err != nil {
+		http.Error(w, "Thumbnails not ready", http.StatusNotFound)
+		return
+	}
+
+	// The version parameter changes as frames fill in, defeating caches
+	sprite := thumbnailURL(infoHash, filePath, fmt.Sprintf("sprite&v=%d", m.doneCount()))
+
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for i, done := range m.Done {
+		if !done {
+			continue
+		}
+		start := float64(i) * m.Interval
+		end := math.Min(start+m.Interval, m.Duration)
+		x, y := (i%m.Columns)*m.Width, (i/m.Columns)*m.Height
+		fmt.Fprintf(&b, "%s --> %s\n%s#xywh=%d,%d,%d,%d\n\n",
+			vttTimestamp(start), vttTimestamp(end), sprite, x, y, m.Width, m.Height)
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(b.String()))
+}
+
+func vttTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nebyat19/Torrent-Streamer/logger"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/tracker"
+)
+
+const (
+	// Tracker status comes from scrapes, which are cached for a while so
+	// polling the endpoint doesn't hammer the trackers
+	trackerScrapeTimeout = 10 * time.Second
+	trackerStatusTTL     = 2 * time.Minute
+
+	maxTrackerListSize = 1 << 20
+)
+
+var (
+	trackerLog = logger.Component("trackers")
+
+	// The default tracker list, one URL per line. TRACKERS_URL, when set,
+	// is where the admin refresh downloads it from.
+	trackersFile   = envOr("TRACKERS_FILE", "data/trackers.txt")
+	trackersURL    = os.Getenv("TRACKERS_URL")
+	trackersAppend = envBool("TRACKERS_APPEND", true)
+)
+
+// trackerList is the default tracker list added to every torrent
+type trackerList struct {
+	mu       sync.RWMutex
+	path     string
+	trackers []string
+	loadedAt time.Time
+}
+
+var defaultTrackers = &trackerList{path: trackersFile}
+
+func (l *trackerList) load() error {
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		data, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.trackers = parseTrackerList(string(data))
+	l.loadedAt = time.Now()
+	return nil
+}
+
+func (l *trackerList) list() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.trackers
+}
+
+// replace saves a new list to the file and loads it
+func (l *trackerList) replace(trackers []string) error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(trackers, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return err
+	}
+	return l.load()
+}
+
+// download fetches the list from TRACKERS_URL and saves it
+func (l *trackerList) download(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", trackersURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", trackersURL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxTrackerListSize))
+	if err != nil {
+		return err
+	}
+
+	trackers := parseTrackerList(string(data))
+	if len(trackers) == 0 {
+		return fmt.Errorf("%s: no trackers in the list", trackersURL)
+	}
+	return l.replace(trackers)
+}
+
+// parseTrackerList reads one tracker URL per line, skipping blank lines,
+// # comments, duplicates and anything that isn't a tracker URL
+func parseTrackerList(data string) []string {
+	var trackers []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || seen[line] || !validTrackerURL(line) {
+			continue
+		}
+		seen[line] = true
+		trackers = append(trackers, line)
+	}
+	return trackers
+}
+
+func validTrackerURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "udp", "http", "https", "ws", "wss":
+		return true
+	}
+	return false
+}
+
+// withDefaultTrackers adds the default list to a torrent spec as an extra
+// tier, leaving out trackers the spec has already
+func withDefaultTrackers(spec *torrent.TorrentSpec) {
+	if !trackersAppend || offlineMode {
+		return
+	}
+	if extra := missingTrackers(spec.Trackers, defaultTrackers.list()); len(extra) > 0 {
+		spec.Trackers = append(spec.Trackers, extra)
+	}
+}
+
+// missingTrackers returns the trackers not in any of tiers
+func missingTrackers(tiers [][]string, trackers []string) []string {
+	have := make(map[string]bool)
+	for _, tier := range tiers {
+		for _, tr := range tier {
+			have[tr] = true
+		}
+	}
+	var extra []string
+	for _, tr := range trackers {
+		if !have[tr] {
+			extra = append(extra, tr)
+		}
+	}
+	return extra
+}
+
+// TrackerStatus is a tracker of a torrent and what its last scrape said
+type TrackerStatus struct {
+	URL       string     `json:"url"`
+	Tier      int        `json:"tier"`
+	Status    string     `json:"status"`
+	Seeders   int        `json:"seeders"`
+	Leechers  int        `json:"leechers"`
+	Completed int        `json:"completed"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+}
+
+// Tracker states
+const (
+	trackerOK          = "ok"
+	trackerFailed      = "error"
+	trackerUnsupported = "unsupported"
+)
+
+type scrapeCache struct {
+	mu      sync.Mutex
+	results map[string]TrackerStatus
+}
+
+var trackerScrapes = &scrapeCache{results: make(map[string]TrackerStatus)}
+
+// trackerStatuses scrapes t's trackers, reusing recent results
+func trackerStatuses(ctx context.Context, t *torrent.Torrent) []TrackerStatus {
+	ih := t.InfoHash()
+	var statuses []TrackerStatus
+	mi := t.Metainfo()
+	for tier, urls := range mi.UpvertedAnnounceList() {
+		for _, u := range urls {
+			statuses = append(statuses, TrackerStatus{URL: u, Tier: tier})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, trackerScrapeTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := range statuses {
+		status := &statuses[i]
+		key := ih.HexString() + " " + status.URL
+
+		trackerScrapes.mu.Lock()
+		cached, ok := trackerScrapes.results[key]
+		trackerScrapes.mu.Unlock()
+		if ok && time.Since(*cached.CheckedAt) < trackerStatusTTL {
+			cached.Tier = status.Tier
+			*status = cached
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer recoverFromPanic("tracker-scrape")
+			scrapeTracker(ctx, ih, status)
+
+			trackerScrapes.mu.Lock()
+			trackerScrapes.results[key] = *status
+			trackerScrapes.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	trackerScrapes.prune()
+	return statuses
+}
+
+func scrapeTracker(ctx context.Context, ih metainfo.Hash, status *TrackerStatus) {
+	now := time.Now()
+	status.CheckedAt = &now
+
+	cl, err := tracker.NewClient(status.URL, tracker.NewClientOpts{Logger: newAnacrolixLogger()})
+	if err != nil {
+		// WebTorrent trackers, for one, can't be scraped
+		status.Status = trackerUnsupported
+		status.Error = err.Error()
+		return
+	}
+	defer cl.Close()
+
+	resp, err := cl.Scrape(ctx, []metainfo.Hash{ih})
+	if err == nil && len(resp) == 0 {
+		err = errors.New("empty scrape response")
+	}
+	if err != nil {
+		status.Status = trackerFailed
+		status.Error = err.Error()
+		return
+	}
+	status.Status = trackerOK
+	status.Seeders = int(resp[0].Seeders)
+	status.Leechers = int(resp[0].Leechers)
+	status.Completed = int(resp[0].Completed)
+}
+
+func (c *scrapeCache) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, status := range c.results {
+		if time.Since(*status.CheckedAt) > trackerStatusTTL {
+			delete(c.results, key)
+		}
+	}
+}
+
+// requestTorrent finds the torrent named by the infoHash parameter, or the
+// session's current one without it. Only admins may name torrents that the
+// caller's session and downloads don't hold.
+func requestTorrent(w http.ResponseWriter, r *http.Request, infoHash string) (*torrent.Torrent, bool) {
+	if infoHash == "" {
+		session := getSession(w, r)
+		sessionLock.Lock()
+		t := session.Torrent
+		sessionLock.Unlock()
+		if t == nil {
+			respondError(w, http.StatusConflict, codeNoStream, "Nothing is streaming")
+			return nil, false
+		}
+		return t, true
+	}
+
+	var ih metainfo.Hash
+	if err := ih.FromHexString(infoHash); err != nil {
+		respondError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid info hash")
+		return nil, false
+	}
+	t, ok := client.Torrent(ih)
+	if ok && !isAdminRequest(r) {
+		ok = queue.heldBy(t, callerHolders(w, r))
+	}
+	if !ok {
+		respondError(w, http.StatusNotFound, codeNotFound, "No such torrent")
+		return nil, false
+	}
+	return t, true
+}
+
+// apiTrackersHandler reports the trackers of a torrent with scrape results
+func apiTrackersHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := requestTorrent(w, r, r.URL.Query().Get("infoHash"))
+	if !ok {
+		return
+	}
+	respondJSON(w, APIResponse{Success: true, Data: trackerStatuses(r.Context(), t)})
+}
+
+// apiAddTrackersHandler adds trackers to a running torrent
+func apiAddTrackersHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		InfoHash string   `json:"infoHash"`
+		Trackers []string `json:"trackers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+		respondError(w, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON")
+		return
+	}
+	trackers := parseTrackerList(strings.Join(requestData.Trackers, "\n"))
+	if len(trackers) != len(requestData.Trackers) || len(trackers) == 0 {
+		respondError(w, http.StatusBadRequest, codeInvalidRequest, "trackers must be distinct udp, http(s) or ws(s) URLs")
+		return
+	}
+	t, ok := requestTorrent(w, r, requestData.InfoHash)
+	if !ok {
+		return
+	}
+
+	t.AddTrackers([][]string{trackers})
+	logger.FromContext(r.Context()).Info("Added %d trackers to %s", len(trackers), t.Name(), logger.InfoHash(t.InfoHash().HexString()))
+	respondJSON(w, APIResponse{Success: true, Message: fmt.Sprintf("Added %d trackers", len(trackers))})
+}
+
+// TrackerList is the default tracker list as reported to admins
+type TrackerList struct {
+	Trackers []string  `json:"trackers"`
+	File     string    `json:"file"`
+	URL      string    `json:"url,omitempty"`
+	Append   bool      `json:"append"`
+	LoadedAt time.Time `json:"loadedAt"`
+}
+
+func trackerListInfo() TrackerList {
+	defaultTrackers.mu.RLock()
+	defer defaultTrackers.mu.RUnlock()
+	return TrackerList{
+		Trackers: append([]string{}, defaultTrackers.trackers...),
+		File:     defaultTrackers.path,
+		URL:      trackersURL,
+		Append:   trackersAppend,
+		LoadedAt: defaultTrackers.loadedAt,
+	}
+}
+
+func apiAdminTrackersHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, APIResponse{Success: true, Data: trackerListInfo()})
+}
+
+// apiAdminRefreshTrackersHandler replaces the default list with the one in
+// the request or, without one, downloads it again from TRACKERS_URL or
+// rereads the file. Torrents already running get the new trackers too.
+func apiAdminRefreshTrackersHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData struct {
+		Trackers []string `json:"trackers"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&requestData)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondError(w, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	status := http.StatusInternalServerError
+	switch {
+	case requestData.Trackers != nil:
+		trackers := parseTrackerList(strings.Join(requestData.Trackers, "\n"))
+		if len(trackers) != len(requestData.Trackers) {
+			respondError(w, http.StatusBadRequest, codeInvalidRequest, "trackers must be distinct udp, http(s) or ws(s) URLs")
+			return
+		}
+		err = defaultTrackers.replace(trackers)
+	case trackersURL != "":
+		status = http.StatusBadGateway
+		err = defaultTrackers.download(r.Context())
+	default:
+		err = defaultTrackers.load()
+	}
+	if err != nil {
+		trackerLog.Error("Could not refresh the tracker list: %v", err)
+		respondError(w, status, codeInternal, "Could not refresh the tracker list: "+err.Error())
+		return
+	}
+
+	trackers := defaultTrackers.list()
+	if trackersAppend && len(trackers) > 0 {
+		for _, t := range client.Torrents() {
+			t.AddTrackers([][]string{trackers})
+		}
+	}
+	trackerLog.Warn("Tracker list refreshed via admin API: %d trackers", len(trackers))
+	respondJSON(w, APIResponse{Success: true, Data: trackerListInfo()})
+}
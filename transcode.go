@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// transcodeProfile is an H.264 output quality selectable with /video?profile=
+type transcodeProfile struct {
+	Name   string `json:"name"`
+	Height int    `json:"height"`
+	// Bitrates are in kbit/s; video is capped at VideoBitrate
+	VideoBitrate int `json:"videoBitrate"`
+	AudioBitrate int `json:"audioBitrate"`
+}
+
+// burnProfile is used when subtitles are burned in without a profile; it
+// never upscales, so it effectively keeps the source resolution
+const burnProfile = "1080p"
+
+var transcodeProfiles = []transcodeProfile{
+	{Name: "480p", Height: 480, VideoBitrate: 1200, AudioBitrate: 128},
+	{Name: "720p", Height: 720, VideoBitrate: 3000, AudioBitrate: 160},
+	{Name: "1080p", Height: 1080, VideoBitrate: 6000, AudioBitrate: 192},
+}
+
+var (
+	// transcodeSlots bounds concurrent encodes; each one keeps CPU cores busy
+	transcodeSlots = make(chan struct{}, transcodeConcurrency())
+
+	transcodePreset     = envOr("TRANSCODE_PRESET", "veryfast")
+	transcodeMaxBitrate = envInt("TRANSCODE_MAX_BITRATE", 0)
+)
+
+func transcodeConcurrency() int {
+	if n := envInt("TRANSCODE_CONCURRENCY", 0); n > 0 {
+		return n
+	}
+	// libx264 at veryfast uses about two cores for 1080p
+	if n := runtime.NumCPU() / 2; n > 1 {
+		return n
+	}
+	return 1
+}
+
+func findTranscodeProfile(name string) (*transcodeProfile, bool) {
+	for i := range transcodeProfiles {
+		if transcodeProfiles[i].Name == name {
+			p := transcodeProfiles[i]
+			if transcodeMaxBitrate > 0 && p.VideoBitrate > transcodeMaxBitrate {
+				p.VideoBitrate = transcodeMaxBitrate
+			}
+			return &p, true
+		}
+	}
+	return nil, false
+}
+
+// profileNames lists the profiles offered to the player
+func profileNames() []string {
+	names := make([]string, 0, len(transcodeProfiles))
+	for _, p := range transcodeProfiles {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// videoArgs returns the ffmpeg encoder settings for a profile. Encoding is
+// software-only libx264 so it runs on any host; sources smaller than the
+// profile keep their size. Filters in before run ahead of scaling, at the
+// source resolution.
+func (p *transcodeProfile) videoArgs(before ...string) []string {
+	filters := append(append([]string{}, before...), fmt.Sprintf(`scale=-2:min(%d\,ih)`, p.Height), "format=yuv420p")
+	return []string{
+		"-vf", strings.Join(filters, ","),
+		"-c:v", "libx264",
+		"-preset", transcodePreset,
+		"-profile:v", "high",
+		"-crf", "23",
+		"-maxrate", fmt.Sprintf("%dk", p.VideoBitrate),
+		"-bufsize", fmt.Sprintf("%dk", p.VideoBitrate*2),
+		// Regular keyframes keep fragments short so playback starts quickly
+		"-g", "48",
+		"-keyint_min", "48",
+	}
+}
+
+func (p *transcodeProfile) audioArgs() []string {
+	return []string{"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", p.AudioBitrate), "-ac", "2"}
+}